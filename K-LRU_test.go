@@ -1,32 +1,327 @@
 package ihe_lru
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestGeneralLRUUse(t *testing.T) {
-	l := NewGeneralLRU(1)
+	l := NewGeneralLRU[string, string](1)
 	// get un-exists item
-	v, ok := l.Get("hello")
-	if ok {
-		t.Log(v)
+	if v, ok := l.Get("hello"); ok {
+		t.Fatalf("should miss, got %v", v)
 	}
 
 	// add hello world
 	l.Add("hello", "world")
 
 	// get exists
-	v, ok = l.Get("hello")
-	if ok {
-		t.Log(v)
+	if v, ok := l.Get("hello"); !ok || v != "world" {
+		t.Fatalf("want world, got %v %v", v, ok)
 	}
 
 	// add exceeded item to ensure evict fine
 	l.Add("good", "kangkang")
-	v, ok = l.Get("hello")
-	if ok {
-		t.Log(v)
+	if _, ok := l.Get("hello"); ok {
+		t.Fatal("hello should be evicted")
 	}
-	v, ok = l.Get("good")
-	if ok {
-		t.Log(v)
+	if v, ok := l.Get("good"); !ok || v != "kangkang" {
+		t.Fatalf("want kangkang, got %v %v", v, ok)
+	}
+}
+
+func TestGeneralLRUTypedValue(t *testing.T) {
+	type user struct {
+		name string
+		age  int
+	}
+	l := NewGeneralLRU[int, *user](2)
+	l.Add(1, &user{name: "kangkang", age: 18})
+	l.Add(2, &user{name: "jane", age: 20})
+
+	u, ok := l.Get(1)
+	if !ok || u.name != "kangkang" {
+		t.Fatal("should has user 1")
+	}
+
+	// 2 is the least recently used now
+	l.Add(3, &user{name: "michael", age: 22})
+	if _, ok = l.Get(2); ok {
+		t.Fatal("user 2 should be evicted")
+	}
+	if u, ok = l.Get(3); !ok || u.age != 22 {
+		t.Fatal("should has user 3")
+	}
+}
+
+func TestGeneralLRUCacheAPI(t *testing.T) {
+	l := NewGeneralLRU[string, int](3)
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+
+	// peek and contains do not touch recency, a is still the oldest
+	if v, ok := l.Peek("a"); !ok || v != 1 {
+		t.Fatal("peek a failed")
+	}
+	if !l.Contains("a") || l.Contains("d") {
+		t.Fatal("contains failed")
+	}
+	if k, v, ok := l.GetOldest(); !ok || k != "a" || v != 1 {
+		t.Fatalf("oldest should be a, got %v", k)
+	}
+
+	l.Get("a")
+	keys := l.Keys()
+	if len(keys) != 3 || keys[0] != "a" || keys[1] != "c" || keys[2] != "b" {
+		t.Fatalf("unexpected keys order %v", keys)
+	}
+
+	if k, _, ok := l.RemoveOldest(); !ok || k != "b" {
+		t.Fatalf("should remove b, got %v", k)
+	}
+	if !l.Remove("c") || l.Remove("c") {
+		t.Fatal("remove c failed")
+	}
+	if l.Len() != 1 {
+		t.Fatalf("bad len %d", l.Len())
+	}
+
+	l.Purge()
+	if l.Len() != 0 || l.Contains("a") {
+		t.Fatal("purge failed")
+	}
+	if _, _, ok := l.RemoveOldest(); ok {
+		t.Fatal("empty lru has no oldest")
+	}
+}
+
+func TestGeneralLRUAddUpsert(t *testing.T) {
+	l := NewGeneralLRU[string, string](2)
+	if _, _, evicted := l.Add("hello", "world"); evicted {
+		t.Fatal("should not evict")
+	}
+	l.Add("good", "kangkang")
+
+	// update existing key, no eviction and hello becomes the most recent
+	if _, _, evicted := l.Add("hello", "jane"); evicted {
+		t.Fatal("update should not evict")
+	}
+	if v, ok := l.Get("hello"); !ok || v != "jane" {
+		t.Fatalf("hello should be updated, got %v", v)
+	}
+
+	k, v, evicted := l.Add("very", "well")
+	if !evicted || k != "good" || v != "kangkang" {
+		t.Fatalf("should evict good, got %v %v %v", k, v, evicted)
+	}
+}
+
+func TestGeneralLRUOnEvict(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	l := NewGeneralLRUWithOptions[string, string](2, Options[string, string]{
+		OnEvict: func(key, value string, reason EvictReason) {
+			reasons[key+":"+value] = reason
+		},
+	})
+	l.Add("a", "1")
+	l.Add("b", "2")
+	l.Add("a", "3")
+	l.Add("c", "4")
+	l.Remove("a")
+	l.Purge()
+
+	expected := map[string]EvictReason{
+		"a:1": EvictByReplace,
+		"b:2": EvictByCapacity,
+		"a:3": EvictByRemove,
+		"c:4": EvictByRemove,
+	}
+	if len(reasons) != len(expected) {
+		t.Fatalf("unexpected evictions %v", reasons)
+	}
+	for k, r := range expected {
+		if reasons[k] != r {
+			t.Fatalf("%s should be evicted by %v, got %v", k, r, reasons[k])
+		}
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestGeneralLRUTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	var expired []string
+	l := NewGeneralLRUWithOptions[string, string](10, Options[string, string]{
+		ExpireAfterWrite: time.Minute,
+		Now:              clock.Now,
+		OnEvict: func(key, value string, reason EvictReason) {
+			if reason == EvictByExpire {
+				expired = append(expired, key)
+			}
+		},
+	})
+	l.Add("session", "kangkang")
+	l.AddWithTTL("token", "jane", time.Second)
+
+	clock.Advance(2 * time.Second)
+	if _, ok := l.Get("token"); ok {
+		t.Fatal("token should be expired")
+	}
+	if _, ok := l.Get("session"); !ok {
+		t.Fatal("session should not be expired")
+	}
+
+	clock.Advance(time.Minute)
+	if l.Len() != 0 {
+		t.Fatalf("all should be expired, len %d", l.Len())
+	}
+	if len(expired) != 2 || expired[0] != "token" || expired[1] != "session" {
+		t.Fatalf("unexpected expired %v", expired)
+	}
+}
+
+// TestGeneralLRUExpiredReclaimed 过期元素在任何方法中都先被回收，不会被Remove、Resize、Purge当作删除或容量驱逐
+func TestGeneralLRUExpiredReclaimed(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	reasons := make(map[string]EvictReason)
+	l := NewGeneralLRUWithOptions[string, string](4, Options[string, string]{
+		ExpireAfterWrite: time.Minute,
+		Now:              clock.Now,
+		OnEvict: func(key, value string, reason EvictReason) {
+			reasons[key] = reason
+		},
+	})
+	l.Add("a", "1")
+	l.Add("b", "2")
+	clock.Advance(30 * time.Second)
+	l.Add("c", "3")
+	l.Add("d", "4")
+
+	clock.Advance(40 * time.Second)
+	if l.Remove("a") {
+		t.Fatal("expired a should not be removed")
+	}
+	if evicted := l.Resize(2); evicted != 0 {
+		t.Fatalf("expired entries should make room, got %d capacity evictions", evicted)
+	}
+	clock.Advance(time.Minute)
+	l.Purge()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if reasons[key] != EvictByExpire {
+			t.Fatalf("%s should expire, got reasons %v", key, reasons)
+		}
+	}
+}
+
+func TestGeneralLRUExpireAfterAccess(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	l := NewGeneralLRUWithOptions[string, string](10, Options[string, string]{
+		ExpireAfterAccess: time.Minute,
+		Now:               clock.Now,
+	})
+	l.Add("hot", "1")
+	l.Add("cold", "2")
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(40 * time.Second)
+		if _, ok := l.Get("hot"); !ok {
+			t.Fatal("hot should be kept alive by access")
+		}
+	}
+	if l.Contains("cold") {
+		t.Fatal("cold should be expired")
+	}
+}
+
+func TestGeneralLRUWeight(t *testing.T) {
+	var evicted []string
+	l := NewGeneralLRUWithOptions[string, []byte](0, Options[string, []byte]{
+		Weigher: func(key string, value []byte) int64 {
+			return int64(len(value))
+		},
+		MaxWeight: 100,
+		OnEvict: func(key string, value []byte, reason EvictReason) {
+			if reason == EvictByCapacity {
+				evicted = append(evicted, key)
+			}
+		},
+	})
+	l.Add("a", make([]byte, 40))
+	l.Add("b", make([]byte, 40))
+	l.Add("c", make([]byte, 10))
+
+	// need to evict both a and b to hold d
+	k, _, ok := l.Add("d", make([]byte, 90))
+	if !ok || k != "a" {
+		t.Fatalf("should evict a first, got %v", k)
+	}
+	if len(evicted) != 2 || l.Contains("b") || !l.Contains("c") || !l.Contains("d") {
+		t.Fatalf("unexpected evicted %v, keys %v", evicted, l.Keys())
+	}
+
+	// oversized entry is rejected
+	k, _, ok = l.Add("huge", make([]byte, 101))
+	if !ok || k != "huge" || l.Contains("huge") {
+		t.Fatal("huge should be rejected")
+	}
+	if l.Len() != 2 {
+		t.Fatalf("bad len %d", l.Len())
+	}
+}
+
+func TestGeneralLRUResize(t *testing.T) {
+	l := NewGeneralLRU[int, int](4)
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	l.Get(0)
+
+	if evicted := l.Resize(2); evicted != 2 {
+		t.Fatalf("should evict 2, got %d", evicted)
+	}
+	keys := l.Keys()
+	if len(keys) != 2 || keys[0] != 0 || keys[1] != 3 {
+		t.Fatalf("should keep the most recent, got %v", keys)
+	}
+
+	if evicted := l.Resize(3); evicted != 0 {
+		t.Fatalf("grow should not evict, got %d", evicted)
+	}
+	l.Add(4, 4)
+	if l.Len() != 3 {
+		t.Fatalf("bad len %d", l.Len())
+	}
+}
+
+func TestGeneralLRUStats(t *testing.T) {
+	l := NewGeneralLRU[string, int](2)
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Get("a")
+	l.Get("c")
+	l.Add("a", 3)
+	l.Add("c", 4)
+	l.Remove("a")
+
+	s := l.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.HitRatio() != 0.5 {
+		t.Fatalf("bad hits %d misses %d", s.Hits, s.Misses)
+	}
+	if s.Evictions[EvictByReplace] != 1 || s.Evictions[EvictByCapacity] != 1 || s.Evictions[EvictByRemove] != 1 {
+		t.Fatalf("bad evictions %v", s.Evictions)
+	}
+	if s.Size != 1 || s.Weight != 1 {
+		t.Fatalf("bad size %d weight %d", s.Size, s.Weight)
 	}
 }
@@ -0,0 +1,30 @@
+package ihe_lru
+
+// Cache 各缓存实现共同的接口，线程安全。业务只依赖Cache，就可以通过配置切换淘汰策略而不改代码
+// 后台异步维护的实现中，Set之后的驱逐可能滞后，Len、Stats只是近似值
+type Cache[K comparable, V any] interface {
+	// Get 返回key对应内容，以及是否存在
+	Get(key K) (V, bool)
+
+	// Set 添加或更新key对应内容。带准入的策略可能拒绝放入新key
+	Set(key K, value V)
+
+	// Remove 删除key对应内容，返回是否存在
+	Remove(key K) bool
+
+	// Len 返回缓存元素个数
+	Len() int
+
+	// Stats 返回命中、驱逐等统计
+	Stats() Stats
+
+	// Close 释放缓存占用的后台资源，之后不应再使用缓存
+	Close() error
+}
+
+// NewSyncCache 以互斥锁包装l得到Cache，之后对l的访问都应通过返回值进行
+func NewSyncCache[K comparable, V any](l LRU[K, V]) Cache[K, V] {
+	return &loadingLRU[K, V]{
+		l: l,
+	}
+}
@@ -0,0 +1,295 @@
+// Package cachetest 各缓存实现共用的一致性测试
+// 实现方提供Factory，RunConformance检查容量上限、Set后Get、Remove、更新、驱逐回调、过期、并发安全，
+// 以及Close后没有泄露goroutine。新增的实现只需在测试中调用一次RunConformance。并发安全需配合-race运行
+package cachetest
+
+import (
+	"learn/ihe-lru"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Config 构造被测缓存的参数，Factory应原样传给实现
+type Config struct {
+	Size             int
+	OnEvict          func(key, value string, reason ihe_lru.EvictReason)
+	ExpireAfterWrite time.Duration
+	Now              func() time.Time
+}
+
+// Factory 按cfg构造被测缓存
+type Factory func(cfg Config) (ihe_lru.Cache[string, string], error)
+
+// Options 说明实现与一般缓存不同的地方，零值表示同步、严格遵守容量并支持过期
+type Options struct {
+	// Async 添加、清理由后台goroutine完成，断言时等待至多Timeout
+	Async bool
+	// Timeout Async时等待的时长，默认1s
+	Timeout time.Duration
+	// SkipCapacity 元素个数不受Size严格限制，不检查容量上限与容量驱逐回调
+	SkipCapacity bool
+	// SkipExpiry 不支持过期
+	SkipExpiry bool
+}
+
+const defaultSize = 16
+
+func RunConformance(t *testing.T, factory Factory) {
+	RunConformanceWithOptions(t, factory, Options{})
+}
+
+func RunConformanceWithOptions(t *testing.T, factory Factory, opts Options) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	s := &suite{factory: factory, opts: opts}
+	t.Run("GetAfterSet", s.testGetAfterSet)
+	t.Run("Update", s.testUpdate)
+	t.Run("Remove", s.testRemove)
+	t.Run("RemoveAfterSet", s.testRemoveAfterSet)
+	if !opts.SkipCapacity {
+		t.Run("Capacity", s.testCapacity)
+	}
+	if !opts.SkipExpiry {
+		t.Run("Expiry", s.testExpiry)
+	}
+	t.Run("Concurrent", s.testConcurrent)
+}
+
+type suite struct {
+	factory Factory
+	opts    Options
+}
+
+// newCache 构造缓存并记录全部驱逐回调，测试结束时Close并检查goroutine泄露
+func (s *suite) newCache(t *testing.T, cfg Config) (ihe_lru.Cache[string, string], *evictions) {
+	t.Helper()
+	CheckLeaks(t)
+	ev := &evictions{}
+	cfg.OnEvict = ev.record
+	c, err := s.factory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("close: %v", err)
+		}
+	})
+	return c, ev
+}
+
+// eventually 同步实现只检查一次，异步实现等待f成立
+func (s *suite) eventually(t *testing.T, f func() bool, format string, args ...any) {
+	t.Helper()
+	deadline := time.Now().Add(s.opts.Timeout)
+	for !f() {
+		if !s.opts.Async || time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *suite) testGetAfterSet(t *testing.T) {
+	c, _ := s.newCache(t, Config{Size: defaultSize})
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("empty cache should miss")
+	}
+	c.Set("a", "1")
+	s.eventually(t, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == "1"
+	}, "should get a after set")
+	s.eventually(t, func() bool { return c.Len() == 1 }, "want len 1, got %d", c.Len())
+}
+
+func (s *suite) testUpdate(t *testing.T) {
+	c, ev := s.newCache(t, Config{Size: defaultSize})
+	c.Set("a", "1")
+	s.eventually(t, func() bool { return c.Len() == 1 }, "a should be added")
+	c.Set("a", "2")
+	s.eventually(t, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == "2"
+	}, "should get updated value")
+	if c.Len() != 1 {
+		t.Fatalf("update should not add, got len %d", c.Len())
+	}
+	s.eventually(t, func() bool { return ev.count(ihe_lru.EvictByReplace) == 1 },
+		"want 1 replace callback, got %d", ev.count(ihe_lru.EvictByReplace))
+}
+
+func (s *suite) testRemove(t *testing.T) {
+	c, ev := s.newCache(t, Config{Size: defaultSize})
+	if c.Remove("a") {
+		t.Fatal("remove missing key should return false")
+	}
+	c.Set("a", "1")
+	s.eventually(t, func() bool { return c.Len() == 1 }, "a should be added")
+	if !c.Remove("a") {
+		t.Fatal("remove existing key should return true")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("removed key should miss")
+	}
+	if c.Remove("a") {
+		t.Fatal("remove twice should return false")
+	}
+	if n := ev.count(ihe_lru.EvictByRemove); n != 1 {
+		t.Fatalf("want 1 remove callback, got %d", n)
+	}
+	s.eventually(t, func() bool { return c.Len() == 0 }, "want len 0, got %d", c.Len())
+}
+
+// testRemoveAfterSet Set之后立即Remove，异步实现在后台处理完添加后也不能再出现被删除的元素。
+// 带准入的策略可能拒绝新key，此时Remove返回false，但同样不能出现
+func (s *suite) testRemoveAfterSet(t *testing.T) {
+	c, ev := s.newCache(t, Config{Size: defaultSize})
+	n := defaultSize / 2
+	var removed int
+	for i := 0; i < n; i++ {
+		key := strconv.Itoa(i)
+		c.Set(key, "v")
+		if c.Remove(key) {
+			removed++
+		}
+		if _, ok := c.Get(key); ok {
+			t.Fatalf("removed key %s should miss", key)
+		}
+	}
+	if m := ev.count(ihe_lru.EvictByRemove); m != removed {
+		t.Fatalf("want %d remove callbacks, got %d", removed, m)
+	}
+	// 准入可能拒绝last，未放入时重试
+	s.eventually(t, func() bool {
+		if _, ok := c.Get("last"); !ok {
+			c.Set("last", "v")
+		}
+		return c.Len() == 1
+	}, "want only last, got len %d", c.Len())
+	// 后台处理完成没有可观察的时刻，异步实现再观察一段时间，被删除的元素不能出现
+	deadline := time.Now().Add(s.opts.Timeout / 10)
+	for {
+		for i := 0; i < n; i++ {
+			if _, ok := c.Get(strconv.Itoa(i)); ok {
+				t.Fatalf("removed key %d should not reappear", i)
+			}
+		}
+		if l := c.Len(); l != 1 {
+			t.Fatalf("want only last, got len %d", l)
+		}
+		if !s.opts.Async || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// testCapacity 元素个数最终不超过Size，离开的元素都有容量驱逐回调
+func (s *suite) testCapacity(t *testing.T) {
+	c, ev := s.newCache(t, Config{Size: defaultSize})
+	n := defaultSize * 10
+	for i := 0; i < n; i++ {
+		c.Set(strconv.Itoa(i), "v")
+	}
+	// 异步实现刚Set完时元素可能还没加入，个数与回调需同时满足
+	s.eventually(t, func() bool {
+		l := c.Len()
+		return l <= defaultSize && ev.count(ihe_lru.EvictByCapacity)+l == n
+	}, "want len <= %d and capacity callbacks for the rest, got len %d callbacks %d",
+		defaultSize, c.Len(), ev.count(ihe_lru.EvictByCapacity))
+
+	var present int
+	for i := 0; i < n; i++ {
+		if _, ok := c.Get(strconv.Itoa(i)); ok {
+			present++
+		}
+	}
+	if present > defaultSize {
+		t.Fatalf("want at most %d keys present, got %d", defaultSize, present)
+	}
+}
+
+// testExpiry 过期元素对Get立即不可见，不必等待后台清理
+func (s *suite) testExpiry(t *testing.T) {
+	clock := NewFakeClock()
+	c, _ := s.newCache(t, Config{Size: defaultSize, ExpireAfterWrite: time.Minute, Now: clock.Now})
+	c.Set("a", "1")
+	s.eventually(t, func() bool {
+		_, ok := c.Get("a")
+		return ok
+	}, "a should not expire yet")
+	clock.Advance(30 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a should not expire yet")
+	}
+	clock.Advance(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should expire")
+	}
+}
+
+// testConcurrent 并发读写删，检查容量与命中统计
+func (s *suite) testConcurrent(t *testing.T) {
+	c, _ := s.newCache(t, Config{Size: defaultSize})
+	const goroutines, ops = 8, 2000
+	var gets atomic.Uint64
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(g), 0))
+			for i := 0; i < ops; i++ {
+				key := strconv.Itoa(r.IntN(defaultSize * 4))
+				switch x := r.IntN(10); {
+				case x < 6:
+					c.Get(key)
+					gets.Add(1)
+				case x < 9:
+					c.Set(key, key)
+				default:
+					c.Remove(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !s.opts.SkipCapacity {
+		s.eventually(t, func() bool { return c.Len() <= defaultSize }, "want len <= %d, got %d", defaultSize, c.Len())
+	}
+	st := c.Stats()
+	if st.Hits+st.Misses != gets.Load() {
+		t.Fatalf("want %d gets in stats, got hits %d misses %d", gets.Load(), st.Hits, st.Misses)
+	}
+	for i := 0; i < defaultSize*4; i++ {
+		key := strconv.Itoa(i)
+		if v, ok := c.Get(key); ok && v != key {
+			t.Fatalf("want %s, got %s", key, v)
+		}
+	}
+}
+
+// evictions 按原因统计驱逐回调，回调可能来自后台goroutine
+type evictions struct {
+	mu     sync.Mutex
+	counts [ihe_lru.EvictByReplace + 1]int
+}
+
+func (e *evictions) record(key, value string, reason ihe_lru.EvictReason) {
+	e.mu.Lock()
+	e.counts[reason]++
+	e.mu.Unlock()
+}
+
+func (e *evictions) count(reason ihe_lru.EvictReason) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.counts[reason]
+}
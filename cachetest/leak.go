@@ -0,0 +1,64 @@
+package cachetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// modulePath 只检查本仓库代码中的goroutine，标准库、测试框架自身的不算泄露
+var modulePath = strings.TrimSuffix(reflect.TypeOf(FakeClock{}).PkgPath(), "/cachetest")
+
+// leakLabel 标记调用CheckLeaks的测试创建的goroutine，leakSeq 区分各次调用
+const leakLabel = "cachetest.leak"
+
+var leakSeq atomic.Int64
+
+// CheckLeaks 测试结束时检查本测试启动的、运行本仓库代码的goroutine是否都已退出，最多等待1s
+// 应在构造缓存之前调用，这样通过t.Cleanup注册的Close会先于检查执行。
+// 调用CheckLeaks的goroutine带上pprof标签，之后它直接或间接创建的goroutine都会继承，
+// 只检查带这个标签的goroutine，之前的测试没有关闭的缓存不算在本测试头上
+func CheckLeaks(t testing.TB) {
+	t.Helper()
+	id := strconv.FormatInt(leakSeq.Add(1), 10)
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(leakLabel, id)))
+	t.Cleanup(func() {
+		// 检查本身不算在内
+		pprof.SetGoroutineLabels(context.Background())
+		deadline := time.Now().Add(time.Second)
+		for {
+			leaked := labeledGoroutines(id)
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("goroutines leaked:\n\n%s", strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// labeledGoroutines 带标签leakLabel=id、运行本仓库代码的goroutine调用栈，调用栈相同的合并为一条，以个数开头
+func labeledGoroutines(id string) []string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		panic(err)
+	}
+	label := fmt.Sprintf("# labels: {%q:%q}", leakLabel, id)
+	var r []string
+	for _, g := range strings.Split(string(bytes.TrimSpace(buf.Bytes())), "\n\n") {
+		if strings.Contains(g, label) && strings.Contains(g, modulePath+"/") {
+			r = append(r, g)
+		}
+	}
+	return r
+}
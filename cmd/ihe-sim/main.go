@@ -0,0 +1,251 @@
+// ihe-sim 回放访问trace，比较各缓存策略在不同容量下的命中率、字节命中率与吞吐
+//
+//	ihe-sim -trace P8.lis -format arc -sizes 1000,10000,100000
+//	ihe-sim -trace access.csv -format csv -policies lru,tinylfu -output csv
+//	ihe-sim -workload zipf -keys 100000 -count 1000000 -theta 0.99
+//
+// 每次读先Get，未命中时Set，与业务中查不到再回源的流程一致；写直接Set，不计入命中率
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"learn/ihe-lru"
+	"learn/ihe-lru/policy"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type config struct {
+	tracePath string
+	format    string
+	limit     int
+
+	workload string
+	keys     uint64
+	count    int
+	theta    float64
+	writes   float64
+	seed     uint64
+
+	policies string
+	sizes    string
+	output   string
+}
+
+type result struct {
+	policy       string
+	size         int
+	accesses     int
+	hitRatio     float64
+	byteHitRatio float64
+	opsPerSecond float64
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.tracePath, "trace", "", "trace文件，-表示标准输入")
+	flag.StringVar(&cfg.format, "format", "plain", "trace格式: "+strings.Join(sortedNames(traceFormats), ", "))
+	flag.IntVar(&cfg.limit, "limit", 0, "最多回放的访问次数，0表示全部")
+	flag.StringVar(&cfg.workload, "workload", "", "不读trace，改用合成负载: "+strings.Join(sortedNames(workloads), ", "))
+	flag.Uint64Var(&cfg.keys, "keys", 100000, "合成负载的key个数")
+	flag.IntVar(&cfg.count, "count", 1000000, "合成负载的访问次数")
+	flag.Float64Var(&cfg.theta, "theta", 0.99, "zipf的倾斜程度")
+	flag.Float64Var(&cfg.writes, "writes", 0, "合成负载中写的比例")
+	flag.Uint64Var(&cfg.seed, "seed", 1, "合成负载的随机种子")
+	flag.StringVar(&cfg.policies, "policies", strings.Join(policy.Names(), ","), "逗号分隔的策略")
+	flag.StringVar(&cfg.sizes, "sizes", "100,1000,10000", "逗号分隔的缓存容量(元素个数)")
+	flag.StringVar(&cfg.output, "output", "table", "输出格式: table, csv")
+	flag.Parse()
+
+	if err := run(cfg, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ihe-sim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config, w io.Writer) error {
+	ps, err := parsePolicies(cfg.policies)
+	if err != nil {
+		return err
+	}
+	ss, err := parseSizes(cfg.sizes)
+	if err != nil {
+		return err
+	}
+	accesses, err := loadAccesses(cfg)
+	if err != nil {
+		return err
+	}
+	if len(accesses) == 0 {
+		return fmt.Errorf("no access to replay")
+	}
+
+	var results []result
+	for _, name := range ps {
+		for _, size := range ss {
+			c, err := newCache(name, size)
+			if err != nil {
+				return fmt.Errorf("new %s(%d): %w", name, size, err)
+			}
+			res := simulate(c, accesses)
+			c.Close()
+			res.policy, res.size = name, size
+			results = append(results, res)
+		}
+	}
+
+	switch cfg.output {
+	case "table":
+		return writeTable(w, results)
+	case "csv":
+		return writeCSV(w, results)
+	default:
+		return fmt.Errorf("unknown output %q", cfg.output)
+	}
+}
+
+// loadAccesses 读取trace，或按-workload生成合成负载
+func loadAccesses(cfg config) ([]access, error) {
+	if cfg.workload != "" {
+		newGen, ok := workloads[cfg.workload]
+		if !ok {
+			return nil, fmt.Errorf("unknown workload %q", cfg.workload)
+		}
+		return generate(newGen(cfg), cfg), nil
+	}
+
+	parse, ok := traceFormats[cfg.format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", cfg.format)
+	}
+	if cfg.tracePath == "" {
+		return nil, fmt.Errorf("-trace or -workload is required")
+	}
+	var r io.Reader = os.Stdin
+	if cfg.tracePath != "-" {
+		f, err := os.Open(cfg.tracePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	accesses, err := parse(r, cfg.limit)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cfg.tracePath, err)
+	}
+	return accesses, nil
+}
+
+// simulate 依次回放全部访问，吞吐包含未命中时Set的耗时
+func simulate(c ihe_lru.Cache[string, int64], accesses []access) result {
+	var reads, hits, hitBytes, totalBytes int64
+	start := time.Now()
+	for _, a := range accesses {
+		if a.write {
+			c.Set(a.key, a.size)
+			continue
+		}
+		reads++
+		totalBytes += a.size
+		if _, ok := c.Get(a.key); ok {
+			hits++
+			hitBytes += a.size
+			continue
+		}
+		c.Set(a.key, a.size)
+	}
+	elapsed := time.Since(start)
+
+	res := result{accesses: len(accesses)}
+	if reads > 0 {
+		res.hitRatio = float64(hits) / float64(reads)
+	}
+	if totalBytes > 0 {
+		res.byteHitRatio = float64(hitBytes) / float64(totalBytes)
+	}
+	if elapsed > 0 {
+		res.opsPerSecond = float64(len(accesses)) / elapsed.Seconds()
+	}
+	return res
+}
+
+func writeTable(w io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "policy\tsize\taccesses\thit ratio\tbyte hit ratio\tops/s\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%.2f%%\t%.0f\t\n",
+			r.policy, r.size, r.accesses, r.hitRatio*100, r.byteHitRatio*100, r.opsPerSecond)
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"policy", "size", "accesses", "hit_ratio", "byte_hit_ratio", "ops_per_second"})
+	for _, r := range results {
+		cw.Write([]string{
+			r.policy,
+			strconv.Itoa(r.size),
+			strconv.Itoa(r.accesses),
+			strconv.FormatFloat(r.hitRatio, 'f', 6, 64),
+			strconv.FormatFloat(r.byteHitRatio, 'f', 6, 64),
+			strconv.FormatFloat(r.opsPerSecond, 'f', 0, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func parsePolicies(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !knownPolicy(name) {
+			return nil, fmt.Errorf("unknown policy %q, want one of %s", name, strings.Join(policy.Names(), ", "))
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no policy")
+	}
+	return names, nil
+}
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		size, err := strconv.Atoi(f)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("bad size %q", f)
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no size")
+	}
+	return sizes, nil
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"learn/ihe-lru"
+	"learn/ihe-lru/policy"
+	"slices"
+)
+
+// newCache 按策略名构造被回放的缓存，value记录元素大小。并发实现的添加、清理是异步的，结果只是近似
+func newCache(name string, size int) (ihe_lru.Cache[string, int64], error) {
+	return policy.New(policy.Config[int64]{Policy: name, Size: size})
+}
+
+func knownPolicy(name string) bool {
+	return slices.Contains(policy.Names(), name)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTraces(t *testing.T) {
+	cases := []struct {
+		format string
+		trace  string
+		keys   []string
+	}{
+		{"plain", "a\n# comment\n\nb 10\na\n", []string{"a", "b", "a"}},
+		{"lirs", "1\n*\n2\n1\n", []string{"1", "2", "1"}},
+		{"arc", "10 3 0 1\n5 1 0 2\n", []string{"10", "11", "12", "5"}},
+		{"csv", "ts,key,size\n1.5,a,100\n2,b,\n", []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		accesses, err := traceFormats[c.format](strings.NewReader(c.trace), 0)
+		if err != nil {
+			t.Fatalf("%s: %v", c.format, err)
+		}
+		if len(accesses) != len(c.keys) {
+			t.Fatalf("%s: want %v, got %v", c.format, c.keys, accesses)
+		}
+		for i, a := range accesses {
+			if a.key != c.keys[i] {
+				t.Fatalf("%s: want %v, got %v", c.format, c.keys, accesses)
+			}
+		}
+	}
+
+	accesses, _ := parseCSV(strings.NewReader("1,a,100\n2,b,\n"), 0)
+	if accesses[0].size != 100 || accesses[1].size != 1 {
+		t.Fatalf("bad csv sizes %v", accesses)
+	}
+	if accesses, _ = parseARC(strings.NewReader("10 100 0 1\n"), 5); len(accesses) != 5 {
+		t.Fatalf("should stop at limit, got %d", len(accesses))
+	}
+	if _, err := parsePlain(strings.NewReader("a x\n"), 0); err == nil {
+		t.Fatal("should reject bad size")
+	}
+}
+
+func TestRun(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace.txt")
+	var sb strings.Builder
+	for i := 0; i < 1000; i++ {
+		sb.WriteString("hot\n")
+		sb.WriteString(strings.Repeat("x", i%50+1) + "\n")
+	}
+	if err := os.WriteFile(trace, []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	cfg := config{tracePath: trace, format: "plain", policies: "lru,tinylfu", sizes: "10,100", output: "csv"}
+	if err := run(cfg, out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[1], "lru,10,2000,") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	cfg.policies = "nope"
+	if err := run(cfg, out); err == nil {
+		t.Fatal("should reject unknown policy")
+	}
+}
+
+func TestRunWorkload(t *testing.T) {
+	out := &bytes.Buffer{}
+	cfg := config{workload: "zipf", keys: 1000, count: 10000, theta: 0.99, writes: 0.1, seed: 1,
+		policies: "lru", sizes: "100", output: "table"}
+	if err := run(cfg, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "10000") {
+		t.Fatalf("should replay 10000 accesses:\n%s", out)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"learn/ihe-lru/tinylfu"
+	"strconv"
+	"strings"
+)
+
+// access trace中的一次访问，size用于计算字节命中率，trace没有大小信息时为1
+type access struct {
+	key   string
+	size  int64
+	write bool
+}
+
+// traceParser 解析一种trace格式，limit大于0时最多读取limit次访问
+type traceParser func(r io.Reader, limit int) ([]access, error)
+
+var traceFormats = map[string]traceParser{
+	"plain":  parsePlain,
+	"lirs":   parseLIRS,
+	"arc":    parseARC,
+	"csv":    parseCSV,
+	"binlog": parseBinlog,
+}
+
+// parsePlain 每行一个key，取第一个字段，空行忽略。第二个字段存在时作为大小
+func parsePlain(r io.Reader, limit int) ([]access, error) {
+	var accesses []access
+	err := scanLines(r, func(fields []string) (bool, error) {
+		a := access{key: fields[0], size: 1}
+		if len(fields) > 1 {
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return false, fmt.Errorf("bad size %q: %w", fields[1], err)
+			}
+			a.size = size
+		}
+		accesses = append(accesses, a)
+		return limit <= 0 || len(accesses) < limit, nil
+	})
+	return accesses, err
+}
+
+// parseLIRS LIRS论文使用的trace，每行一个块号，"*"为分隔标记
+func parseLIRS(r io.Reader, limit int) ([]access, error) {
+	var accesses []access
+	err := scanLines(r, func(fields []string) (bool, error) {
+		if fields[0] == "*" {
+			return true, nil
+		}
+		if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil {
+			return false, fmt.Errorf("bad block %q: %w", fields[0], err)
+		}
+		accesses = append(accesses, access{key: fields[0], size: 1})
+		return limit <= 0 || len(accesses) < limit, nil
+	})
+	return accesses, err
+}
+
+// parseARC ARC论文使用的trace，每行"起始块 块数 忽略 请求号"，展开为连续块的访问
+func parseARC(r io.Reader, limit int) ([]access, error) {
+	var accesses []access
+	err := scanLines(r, func(fields []string) (bool, error) {
+		if len(fields) < 2 {
+			return false, fmt.Errorf("bad arc line %q", strings.Join(fields, " "))
+		}
+		start, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("bad start block %q: %w", fields[0], err)
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("bad block count %q: %w", fields[1], err)
+		}
+		for i := uint64(0); i < n; i++ {
+			accesses = append(accesses, access{key: strconv.FormatUint(start+i, 10), size: 1})
+			if limit > 0 && len(accesses) >= limit {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	return accesses, err
+}
+
+// parseCSV 每行"时间戳,key[,大小]"，首行不是数字时视为表头跳过。回放不按时间间隔等待，时间戳只要求存在
+func parseCSV(r io.Reader, limit int) ([]access, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var accesses []access
+	for line := 1; limit <= 0 || len(accesses) < limit; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: want timestamp,key[,size], got %q", line, rec)
+		}
+		if _, err = strconv.ParseFloat(rec[0], 64); err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: bad timestamp %q", line, rec[0])
+		}
+		a := access{key: rec[1], size: 1}
+		if len(rec) > 2 && rec[2] != "" {
+			if a.size, err = strconv.ParseInt(rec[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: bad size %q", line, rec[2])
+			}
+		}
+		accesses = append(accesses, a)
+	}
+	return accesses, nil
+}
+
+// parseBinlog tinylfu.NewRecordingLRU、NewRecordingCache录制的binlog，只回放get，大小取该key最近一次add的重量
+func parseBinlog(r io.Reader, limit int) ([]access, error) {
+	br, err := tinylfu.NewBinlogReader(r)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[uint64]int64)
+	var accesses []access
+	for limit <= 0 || len(accesses) < limit {
+		rec, err := br.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch rec.Op {
+		case tinylfu.OpAdd:
+			weights[rec.Hash] = rec.Weight
+		case tinylfu.OpGet:
+			size, ok := weights[rec.Hash]
+			if !ok {
+				size = 1
+			}
+			accesses = append(accesses, access{key: strconv.FormatUint(rec.Hash, 16), size: size})
+		}
+	}
+	return accesses, nil
+}
+
+// scanLines 按空白切分每一行交给f，f返回false时停止。以#开头的行为注释
+func scanLines(r io.Reader, f func(fields []string) (bool, error)) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		more, err := f(fields)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if !more {
+			return nil
+		}
+	}
+	return s.Err()
+}
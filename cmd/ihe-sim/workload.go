@@ -0,0 +1,37 @@
+package main
+
+import "learn/ihe-lru/workload"
+
+// workloads -workload可选的合成负载
+var workloads = map[string]func(cfg config) workload.Generator{
+	"zipf": func(cfg config) workload.Generator {
+		return workload.NewZipf(cfg.seed, cfg.keys, cfg.theta)
+	},
+	"uniform": func(cfg config) workload.Generator {
+		return workload.NewUniform(cfg.seed, cfg.keys)
+	},
+	"loop": func(cfg config) workload.Generator {
+		return workload.NewLoop(0, cfg.keys)
+	},
+	// hotspot 90%的访问落在1%的key上，热点每keys次访问转移一次
+	"hotspot": func(cfg config) workload.Generator {
+		return workload.NewShiftingHotspot(cfg.seed, cfg.keys, cfg.keys/100, 0.9, int(cfg.keys))
+	},
+	// zipf-scan zipf中夹杂10%的一次性扫描，考验策略抵抗扫描的能力
+	"zipf-scan": func(cfg config) workload.Generator {
+		return workload.NewInterleave(cfg.seed, []float64{9, 1},
+			workload.NewZipf(cfg.seed, cfg.keys, cfg.theta), workload.NewScan(cfg.keys))
+	},
+}
+
+func generate(g workload.Generator, cfg config) []access {
+	if cfg.writes > 0 {
+		g = workload.NewMixed(cfg.seed, g, cfg.writes)
+	}
+	accesses := make([]access, cfg.count)
+	for i := range accesses {
+		a := g.Next()
+		accesses[i] = access{key: a.KeyString(), size: 1, write: a.Op == workload.Write}
+	}
+	return accesses
+}
@@ -0,0 +1,32 @@
+package ihe_lru
+
+// EvictReason 元素离开缓存的原因
+type EvictReason int
+
+const (
+	// EvictByCapacity 超出容量被驱逐
+	EvictByCapacity EvictReason = iota
+	// EvictByRemove 被显式删除
+	EvictByRemove
+	// EvictByExpire 过期
+	EvictByExpire
+	// EvictByReplace 被同key的新value替换
+	EvictByReplace
+
+	evictReasonCount
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictByCapacity:
+		return "capacity"
+	case EvictByRemove:
+		return "remove"
+	case EvictByExpire:
+		return "expire"
+	case EvictByReplace:
+		return "replace"
+	default:
+		return "unknown"
+	}
+}
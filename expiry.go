@@ -0,0 +1,47 @@
+package ihe_lru
+
+import "time"
+
+// expiryHeap 按过期时间排列的小顶堆，堆顶为最先过期的元素。不过期的元素不放入堆中
+type expiryHeap[K comparable, V any] []*item[K, V]
+
+func (h expiryHeap[K, V]) Len() int {
+	return len(h)
+}
+
+func (h expiryHeap[K, V]) Less(i, j int) bool {
+	return h[i].expireAt.Before(h[j].expireAt)
+}
+
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	i := x.(*item[K, V])
+	i.index = len(*h)
+	*h = append(*h, i)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	i := old[n-1]
+	old[n-1] = nil
+	i.index = -1
+	*h = old[:n-1]
+	return i
+}
+
+// deadline 返回更早的过期时间，零值表示不过期
+func deadline(a, b time.Time) time.Time {
+	if a.IsZero() {
+		return b
+	}
+	if b.IsZero() || a.Before(b) {
+		return a
+	}
+	return b
+}
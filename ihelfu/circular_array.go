@@ -41,6 +41,22 @@ func (c *circularArray) Append(key string) {
 	c.tail++
 }
 
+// Resize 调整容量，保留最近Append的元素，返回放不下的较早元素
+func (c *circularArray) Resize(size int) []string {
+	var overflow []string
+	if n := c.Size() - size; n > 0 {
+		overflow = c.Remove(n)
+	}
+	items := make([]string, size)
+	n := c.Size()
+	copy(items, c.Remove(n))
+	c.size = size
+	c.items = items
+	c.head = 0
+	c.tail = n
+	return overflow
+}
+
 func (c *circularArray) Remove(n int) []string {
 	if n > c.tail-c.head {
 		n = c.tail - c.head
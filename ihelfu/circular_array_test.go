@@ -31,3 +31,28 @@ func TestBasicUseCA(t *testing.T) {
 		t.Fatalf("fail to rm %v", x1)
 	}
 }
+
+func TestResizeCA(t *testing.T) {
+	ca := NewCircularArray(4)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		ca.Append(k)
+	}
+	ca.Remove(1)
+	ca.Append("e")
+
+	overflow := ca.Resize(2)
+	if len(overflow) != 2 || overflow[0] != "b" || overflow[1] != "c" {
+		t.Fatalf("should overflow b c, got %v", overflow)
+	}
+	if !ca.IsFull() {
+		t.Fatal("should full with de")
+	}
+
+	if overflow = ca.Resize(3); len(overflow) != 0 || ca.IsFull() {
+		t.Fatalf("grow should keep all, got %v", overflow)
+	}
+	ca.Append("f")
+	if x := ca.Remove(3); len(x) != 3 || x[0] != "d" || x[1] != "e" || x[2] != "f" {
+		t.Fatalf("should keep order, got %v", x)
+	}
+}
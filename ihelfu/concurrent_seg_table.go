@@ -15,15 +15,23 @@ const (
 
 var (
 	ErrFull = errors.New("table full")
+	// errSegFull 单个segTable已满，换一个再试
+	errSegFull = errors.New("seg table full")
 )
 
 type ConcurrentSegTable struct {
 	items     []*segTable
 	sizeLimit int
 	lock      sync.RWMutex
-	full      []uint64
-	m         func(key string) bool
-	segLen    int
+	// full 每个segTable是否已满的位图，按位atomic修改。Add、Clean持有lock读锁时也会修改，所以不能再加写锁
+	full   []atomic.Uint64
+	m      func(key string) bool
+	segLen int
+	// maxItems 元素个数上限，可由SetMaxItems调整；count 当前元素个数。两者atomic读写
+	maxItems atomic.Int64
+	count    atomic.Int64
+	// wg 等待Clean启动的goroutine
+	wg sync.WaitGroup
 }
 
 // limit 64倍数
@@ -34,14 +42,16 @@ func NewConcurrentSegTable(limit int, segLen int, m func(key string) bool) *Conc
 		items[i] = s
 	}
 
-	return &ConcurrentSegTable{
+	t := &ConcurrentSegTable{
 		items:     items,
 		sizeLimit: limit,
 		lock:      sync.RWMutex{},
-		full:      make([]uint64, limit>>6),
+		full:      make([]atomic.Uint64, limit>>6),
 		segLen:    segLen,
 		m:         m,
 	}
+	t.maxItems.Store(int64(limit * segLen))
+	return t
 }
 
 // 如何确保lfu中删除的和evict中删除的一致呢？
@@ -52,9 +62,10 @@ func (t *ConcurrentSegTable) Reset() []string {
 		items[i] = s
 	}
 	t.lock.Lock()
-	t.items = items
-	t.full = make([]uint64, t.sizeLimit>>6)
 	r := t.list()
+	t.items = items
+	t.full = make([]atomic.Uint64, t.sizeLimit>>6)
+	t.count.Store(0)
 	t.lock.Unlock()
 	return r
 }
@@ -85,8 +96,8 @@ func (t *ConcurrentSegTable) Add(key string) error {
 	defer t.lock.RUnlock()
 	for count < len(t.items) {
 		s = t.items[i]
-		if ok := t.add(s, i, key); ok {
-			return nil
+		if err := t.add(s, i, key); err != errSegFull {
+			return err
 		}
 		if i == len(t.items)-1 {
 			i = 0
@@ -104,11 +115,13 @@ func (t *ConcurrentSegTable) AddFront(key string) error {
 		return ErrFull
 	}
 
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	var s *segTable
 	var i int
 	for i, s = range t.items {
-		if ok := t.add(s, i, key); ok {
-			return nil
+		if err := t.add(s, i, key); err != errSegFull {
+			return err
 		}
 	}
 
@@ -120,27 +133,34 @@ func (t *ConcurrentSegTable) AddBack(key string) error {
 		return ErrFull
 	}
 
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	var s *segTable
 	for i := len(t.items) - 1; i >= 0; i-- {
 		s = t.items[i]
-		if ok := t.add(s, i, key); ok {
-			return nil
+		if err := t.add(s, i, key); err != errSegFull {
+			return err
 		}
 	}
 
 	return ErrFull
 }
 
-func (t *ConcurrentSegTable) add(s *segTable, i int, key string) bool {
-	if s.isFull() {
-		return false
-	}
-
+// add 需持有t.lock读锁。s已满返回errSegFull，可以换一个segTable再试；达到maxItems返回ErrFull
+func (t *ConcurrentSegTable) add(s *segTable, i int, key string) error {
 	s.lock()
 	defer s.unlock()
 
 	if s.isFull() {
-		return false
+		return errSegFull
+	}
+	// 已在s中的key不占新的位置
+	if _, ok := s.items[key]; ok {
+		return nil
+	}
+	if t.count.Add(1) > t.maxItems.Load() {
+		t.count.Add(-1)
+		return ErrFull
 	}
 
 	s.state.Store(adding)
@@ -150,41 +170,40 @@ func (t *ConcurrentSegTable) add(s *segTable, i int, key string) bool {
 	if s.isFull() {
 		t.setFull(i)
 	}
-	return true
+	return nil
 }
 
 func (t *ConcurrentSegTable) setFull(i int) {
-	ix := i >> 6
-	iy := i - ix
-	t.lock.Lock()
-	f := t.full[ix]
-	f = f | (1 << iy)
-	t.lock.Unlock()
+	t.full[i>>6].Or(1 << (i & 63))
 }
 
 func (t *ConcurrentSegTable) setEmpty(i int) {
-	ix := i >> 6
-	iy := i - ix
-	t.lock.Lock()
-	f := t.full[ix]
-	f = f & (^(1 << iy))
-	t.lock.Unlock()
+	t.full[i>>6].And(^uint64(1 << (i & 63)))
 }
 
 func (t *ConcurrentSegTable) Clean() {
+	// Reset会替换items，遍历时持读锁
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	var i int
 	var s *segTable
 	for i, s = range t.items {
 		s := s
 		i := i
+		s.rlock()
+		empty := s.isEmpty()
+		s.rUnlock()
 		// 这里还能出现goroutine泄露!!!
-		if !s.isEmpty() && s.state.Load().(int) != cleaning {
+		if !empty && s.state.Load().(int) != cleaning {
+			t.wg.Add(1)
 			go func() {
+				defer t.wg.Done()
 				t.lock.RLock()
 				s.state.Store(cleaning)
 				s.lock()
-				s.clean()
-				if s.isEmpty() {
+				t.count.Add(-int64(s.clean()))
+				// 清理出空位即可再添加
+				if !s.isFull() {
 					t.setEmpty(i)
 				}
 				s.unlock()
@@ -195,12 +214,57 @@ func (t *ConcurrentSegTable) Clean() {
 	}
 }
 
+// SetMaxItems 调整元素个数上限，超过全部segTable的容量之和时以容量为准。超出新上限的元素被移出并返回，由调用方决定去向
+func (t *ConcurrentSegTable) SetMaxItems(n int) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.maxItems.Store(int64(n))
+	over := int(t.count.Load()) - n
+	if over <= 0 {
+		return nil
+	}
+	removed := make([]string, 0, over)
+	for i, s := range t.items {
+		if len(removed) == over {
+			break
+		}
+		s.lock()
+		for key := range s.items {
+			if len(removed) == over {
+				break
+			}
+			s.remove(key)
+			removed = append(removed, key)
+		}
+		if !s.isFull() {
+			t.setEmpty(i)
+		}
+		s.unlock()
+	}
+	t.count.Add(-int64(len(removed)))
+	return removed
+}
+
+// Len 元素个数
+func (t *ConcurrentSegTable) Len() int {
+	return int(t.count.Load())
+}
+
+// Wait 等待Clean启动的goroutine全部结束
+func (t *ConcurrentSegTable) Wait() {
+	t.wg.Wait()
+}
+
+// IsFull 达到maxItems或全部segTable已满
 func (t *ConcurrentSegTable) IsFull() bool {
+	if t.count.Load() >= t.maxItems.Load() {
+		return true
+	}
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
-	for _, f := range t.full {
-		if f != maxUint64 {
+	for i := range t.full {
+		if t.full[i].Load() != maxUint64 {
 			return false
 		}
 	}
@@ -267,11 +331,15 @@ func (s *segTable) remove(key string) {
 	delete(s.items, key)
 }
 
-func (s *segTable) clean() {
+// clean 返回移出的元素个数
+func (s *segTable) clean() int {
+	var n int
 	var key string
 	for key, _ = range s.items {
 		if s.m(key) {
 			s.remove(key)
+			n++
 		}
 	}
+	return n
 }
@@ -0,0 +1,26 @@
+package ihelfu
+
+import (
+	"learn/ihe-lru"
+	"time"
+)
+
+// Options 构造segIheLfu时的可选配置，零值即默认配置
+type Options[V any] struct {
+	// OnEvict 元素离开缓存时回调。在后台驱逐goroutine中调用，调用时不持有锁
+	OnEvict func(key string, value V, reason ihe_lru.EvictReason)
+
+	// ExpireAfterWrite 元素写入后经过该时长过期，0表示不过期。InsertWithTTL指定的ttl优先
+	ExpireAfterWrite time.Duration
+	// ExpireAfterAccess 元素最近一次访问后经过该时长过期，0表示不过期
+	ExpireAfterAccess time.Duration
+	// Now 获取当前时间，默认time.Now
+	Now func() time.Time
+}
+
+func (o Options[V]) now() func() time.Time {
+	if o.Now != nil {
+		return o.Now
+	}
+	return time.Now
+}
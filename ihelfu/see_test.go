@@ -1,7 +1,9 @@
 package ihelfu
 
 import (
-	"learn/tool/fuzz"
+	"learn/ihe-lru/tinylfu"
+	"learn/ihe-lru/workload"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -40,17 +42,78 @@ func TestSEEBasicUse(t *testing.T) {
 	time.Sleep(10 * time.Second)
 }
 
+// TestSEEClimbWindow 全部命中时HillClimber一直调大window，eden、evict的上限随之缩小，各区域的key总数不超过size
+func TestSEEClimbWindow(t *testing.T) {
+	size := int64(100)
+	en := make(chan []string)
+	ie, err := NewIheEvict(size, en)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ie.Close()
+	go func() {
+		for {
+			select {
+			case <-en:
+			case <-ie.done:
+				return
+			}
+		}
+	}()
+
+	limits := func() int {
+		ie.winLock.RLock()
+		defer ie.winLock.RUnlock()
+		return ie.winZone.size + int(ie.edenZone.maxItems.Load()+ie.evictZone.maxItems.Load())
+	}
+	maxWin := windowSize(size, tinylfu.ClampWindowRatio(1))
+	for p := 0; p < 30; p++ {
+		for j := 0; j < ie.cms.SampleSize(); j++ {
+			ie.RecordAccess(true)
+		}
+		for j := 0; j < int(size)*2; j++ {
+			ie.Admit(strconv.Itoa(p) + "-" + strconv.Itoa(j))
+			if j%8 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		if n := ie.Len(); n > int(size) {
+			t.Fatalf("period %d: want len <= %d, got %d", p, size, n)
+		}
+		// 调整过程中先缩小eden、evict再扩大window，上限之和只会暂时小于size
+		if n := limits(); n > int(size) {
+			t.Fatalf("period %d: want window + eden + evict limits <= %d, got %d", p, size, n)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		ie.winLock.RLock()
+		win := ie.winZone.size
+		ie.winLock.RUnlock()
+		n := limits()
+		if win == maxWin && n == int(size) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want window %d and limits %d, got %d and %d", maxWin, size, win, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestBenchSEE(t *testing.T) {
 	count := 1000000
 	ie, err := NewIheEvict(100, make(chan []string))
 	if err != nil {
 		t.Fatal(err)
 	}
+	keys := workload.Keys(workload.NewZipf(1, 10, 0.99), count)
 	wg := &sync.WaitGroup{}
 	wg.Add(count)
 	for i := 0; i < count; i++ {
 		go func() {
-			ie.UpdateAccessCount(fuzz.GenerateRandStringFixedNumber(1))
+			ie.UpdateAccessCount(keys[i])
 			wg.Done()
 		}()
 	}
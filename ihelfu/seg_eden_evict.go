@@ -1,7 +1,7 @@
 package ihelfu
 
 import (
-	"learn/algo/count_min_sketch"
+	"learn/ihe-lru/tinylfu"
 	"math"
 	"math/rand"
 	"sync"
@@ -10,10 +10,8 @@ import (
 )
 
 const (
-	defaultWinCleanDuration    = time.Millisecond
-	defaultEdenCleanDuration   = 10 * time.Millisecond
-	defaultEvictUpdateDuration = 10 * time.Millisecond
-	defaultDelayDuration       = time.Minute
+	defaultWinCleanDuration  = time.Millisecond
+	defaultEdenCleanDuration = 10 * time.Millisecond
 
 	defaultWinSafeRatio  = 0.5
 	defaultEdenSafeRatio = 0.7
@@ -22,13 +20,11 @@ const (
 	edenBackwardRatio = 1
 	evictAdvanceRatio = 1.2
 
+	// 初始划分，window占比之后由HillClimber根据命中率调整
 	winRatio   = 0.2
 	edenRatio  = 0.7
 	evictRatio = 0.1
 
-	defaultEpsilon = 0.01
-	defaultDelta   = 0.01
-
 	defaultEvictPercentage = 3
 )
 
@@ -38,8 +34,9 @@ var (
 )
 
 type iheEvict struct {
-	cms          *count_min_sketch.CMSBitVersion
-	delay2Ticker *time.Ticker
+	// cms 每个采样周期计数减半，不需要另外定期重置
+	cms    *tinylfu.TinyLFU
+	hasher tinylfu.Hasher[string]
 
 	winCleanTicker       *time.Ticker
 	WinCleanCh           chan struct{}
@@ -48,6 +45,14 @@ type iheEvict struct {
 	winSafeSizeThreshold int
 	winAdvanceRatio      float64
 	winZone              *circularArray
+	// size 总大小，window与eden、evict的上限之和。winRatio 当前window占比，只在cleanWindowZone中修改
+	size     int64
+	winRatio float64
+	climber  *tinylfu.HillClimber
+	// pendingAdjust RecordAccess攒下的window占比调整，通知adjustCh后由cleanWindowZone应用
+	adjustLock    sync.Mutex
+	pendingAdjust float64
+	adjustCh      chan struct{}
 
 	edenTimeout           time.Duration
 	edenCleanTicker       *time.Ticker
@@ -57,26 +62,25 @@ type iheEvict struct {
 	edenBackwardRatio     float64
 	edenZone              *ConcurrentSegTable
 
-	evictUpdateTicker *time.Ticker
 	evictAdvanceRatio float64
 	evictZone         *ConcurrentSegTable
 	evictNotify       chan []string
+
+	// done 关闭后各后台goroutine退出，发往evictNotify的key直接丢弃
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 // size should not close int limit
 func NewIheEvict(size int64, en chan []string) (*iheEvict, error) {
-	cms, err := count_min_sketch.NewCMSBitVersion(defaultEpsilon, defaultDelta)
-	if err != nil {
-		return nil, err
-	}
-
-	winSize := int(float64(size) * winRatio)
-	edenSize := int64(float64(size) * edenRatio)
-	evictSize := int64(float64(size) * evictRatio)
+	winSize := windowSize(size, winRatio)
+	edenSize, evictSize := mainSizes(size, winSize)
 
+	cms := tinylfu.NewTinyLFU(int(size), 0)
 	ie := &iheEvict{
-		cms:          cms,
-		delay2Ticker: time.NewTicker(defaultDelayDuration),
+		cms:    cms,
+		hasher: tinylfu.NewHasher[string](),
 
 		winCleanTicker:       time.NewTicker(defaultWinCleanDuration),
 		WinCleanCh:           make(chan struct{}, 1),
@@ -85,6 +89,10 @@ func NewIheEvict(size int64, en chan []string) (*iheEvict, error) {
 		winSafeSizeThreshold: int(float64(winSize) * defaultWinSafeRatio),
 		winAdvanceRatio:      winAdvanceRatio,
 		winZone:              NewCircularArray(winSize),
+		size:                 size,
+		winRatio:             winRatio,
+		climber:              tinylfu.NewHillClimber(cms.SampleSize()),
+		adjustCh:             make(chan struct{}, 1),
 
 		edenCleanTicker:       time.NewTicker(defaultEdenCleanDuration),
 		edenCleanCh:           make(chan struct{}, 1),
@@ -94,16 +102,19 @@ func NewIheEvict(size int64, en chan []string) (*iheEvict, error) {
 
 		evictAdvanceRatio: evictAdvanceRatio,
 		evictNotify:       en,
-		evictUpdateTicker: time.NewTicker(defaultEvictUpdateDuration),
+		done:              make(chan struct{}),
 	}
 
-	edl := getCSTLimit(edenSize)
-	evl := getCSTLimit(evictSize)
-	edenZone := NewConcurrentSegTable(edl, defaultSegLen, ie.checkUnderEden)
-	evictZone := NewConcurrentSegTable(evl, defaultSegLen, ie.checkReachEvict)
+	// eden、evict的上限随window调整，segTable按总大小分配
+	l := getCSTLimit(size)
+	edenZone := NewConcurrentSegTable(l, defaultSegLen, ie.checkUnderEden)
+	evictZone := NewConcurrentSegTable(l, defaultSegLen, ie.checkReachEvict)
+	edenZone.SetMaxItems(edenSize)
+	evictZone.SetMaxItems(evictSize)
 	ie.edenZone = edenZone
 	ie.evictZone = evictZone
 
+	ie.wg.Add(4)
 	go ie.cleanWinZonePeriodically()
 	go ie.cleanEdenZonePeriodically()
 	go ie.cleanWindowZone()
@@ -112,6 +123,25 @@ func NewIheEvict(size int64, en chan []string) (*iheEvict, error) {
 	return ie, nil
 }
 
+// windowSize 按占比计算window大小，至少为1
+func windowSize(size int64, ratio float64) int {
+	winSize := int(float64(size) * ratio)
+	if winSize < 1 {
+		winSize = 1
+	}
+	return winSize
+}
+
+// mainSizes window之外的容量按edenRatio:evictRatio分给eden与evict，三者之和为size
+func mainSizes(size int64, winSize int) (int, int) {
+	main := int(size) - winSize
+	if main < 0 {
+		main = 0
+	}
+	eden := int(float64(main) * edenRatio / (edenRatio + evictRatio))
+	return eden, main - eden
+}
+
 func getCSTLimit(size int64) int {
 	x := int64(math.Ceil(float64(size) / float64(defaultSegLen)))
 	if x < 2 {
@@ -133,37 +163,70 @@ func getCSTLimit(size int64) int {
 // 先一个一个更新吧，将框架先搭起来
 func (i *iheEvict) UpdateAccessCount(key string) bool {
 	// not exists, insert window zone
-	if i.cms.Estimate(key) == 0 {
-		i.cms.Update(key, 1)
-		if !i.winZone.IsFull() {
-			i.winLock.Lock()
-			if !i.winZone.IsFull() {
-				i.winZone.Append(key)
-				i.winLock.Unlock()
-				return true
-			}
-
-			// 如果真的这么倒霉碰到了满的情况，直接丢弃也许问题不大，毕竟下次还会再来
-			i.winLock.Unlock()
-			return false
-		}
-		i.notifyCleanWinZone()
+	if i.estimate(key) == 0 {
+		return i.Admit(key)
 	}
 
 	// exists, just update
-	i.cms.Update(key, 1)
+	i.increment(key)
 	return true
 }
 
-func (i *iheEvict) cleanWinZonePeriodically() {
-	for range i.winCleanTicker.C {
+// Admit 新key记录一次访问并放入window，与估计的访问频率无关：频率可能来自已被淘汰、删除的同一key或hash冲突，
+// 只看频率会让新key不在任何区域中，永远不会被淘汰。window已满时通知清理并返回false
+func (i *iheEvict) Admit(key string) bool {
+	i.increment(key)
+	i.winLock.Lock()
+	ok := !i.winZone.IsFull()
+	if ok {
+		i.winZone.Append(key)
+	}
+	i.winLock.Unlock()
+	if !ok {
+		// 如果真的这么倒霉碰到了满的情况，直接丢弃也许问题不大，毕竟下次还会再来
 		i.notifyCleanWinZone()
 	}
+	return ok
+}
+
+func (i *iheEvict) increment(key string) {
+	i.cms.Increment(i.hasher.Hash(key))
+}
+
+func (i *iheEvict) estimate(key string) uint8 {
+	return i.cms.Estimate(i.hasher.Hash(key))
 }
 
-func (i *iheEvict) delay2Periodically() {
-	for range i.delay2Ticker.C {
-		i.cms.Delay2()
+// Close 停止全部ticker与后台goroutine并等待其退出，可重复调用
+func (i *iheEvict) Close() error {
+	i.closeOnce.Do(func() {
+		i.winCleanTicker.Stop()
+		i.edenCleanTicker.Stop()
+		close(i.done)
+		i.wg.Wait()
+		i.edenZone.Wait()
+		i.evictZone.Wait()
+	})
+	return nil
+}
+
+// sendEvict 通知segIheLfu删除keys。Close后没有接收方，直接丢弃
+func (i *iheEvict) sendEvict(keys []string) {
+	select {
+	case i.evictNotify <- keys:
+	case <-i.done:
+	}
+}
+
+func (i *iheEvict) cleanWinZonePeriodically() {
+	defer i.wg.Done()
+	for {
+		select {
+		case <-i.winCleanTicker.C:
+			i.notifyCleanWinZone()
+		case <-i.done:
+			return
+		}
 	}
 }
 
@@ -180,42 +243,108 @@ func (i *iheEvict) notifyCleanWinZone() {
 }
 
 func (i *iheEvict) cleanWindowZone() {
-	for range i.WinCleanCh {
+	defer i.wg.Done()
+	for {
+		select {
+		case <-i.WinCleanCh:
+		case <-i.adjustCh:
+			i.adjustWindow()
+			continue
+		case <-i.done:
+			return
+		}
+		i.winLock.Lock()
 		n := i.winZone.Size() - i.winSafeSizeThreshold
+		var arr []string
 		if n > 0 {
-			i.winLock.Lock()
-			arr := i.winZone.Remove(n)
-			i.winLock.Unlock()
-			// 如果真是0/1，那似乎也没什么问题啊。但1/10有问题
-			// 怎么能除以0呢？？？
-			var t float64
-			count := atomic.LoadInt64(&totalCount)
-			if count != 0 {
-				t = float64(atomic.LoadInt64(&total)) / float64(atomic.LoadInt64(&totalCount)) * i.winAdvanceRatio
-			}
-			var sc float64
-			for _, s := range arr {
-				sc = float64(i.cms.Estimate(s))
-				if sc > t {
-					// move to eden
-					i.advanceIntoEden(s)
-				} else {
-					// move to evict
-					i.backwardIntoEvict(s)
-				}
-			}
+			arr = i.winZone.Remove(n)
+		}
+		i.winLock.Unlock()
+		i.leaveWindowZone(arr)
+	}
+}
+
+// leaveWindowZone 离开window的元素按访问频率去往eden或evict
+func (i *iheEvict) leaveWindowZone(arr []string) {
+	if len(arr) == 0 {
+		return
+	}
+	// 如果真是0/1，那似乎也没什么问题啊。但1/10有问题
+	// 怎么能除以0呢？？？
+	var t float64
+	count := atomic.LoadInt64(&totalCount)
+	if count != 0 {
+		t = float64(atomic.LoadInt64(&total)) / float64(atomic.LoadInt64(&totalCount)) * i.winAdvanceRatio
+	}
+	var sc float64
+	for _, s := range arr {
+		sc = float64(i.estimate(s))
+		if sc > t {
+			// move to eden
+			i.advanceIntoEden(s)
+		} else {
+			// move to evict
+			i.backwardIntoEvict(s)
 		}
 	}
 }
 
-func (i *iheEvict) updateEvictZone() {
-	for range i.evictUpdateTicker.C {
-		i.evictZone.Clean()
+// RecordAccess 记录一次缓存访问是否命中，不阻塞。每个采样周期结束时攒下HillClimber的调整，
+// 交给cleanWindowZone调整window大小
+func (i *iheEvict) RecordAccess(hit bool) {
+	adjust, ok := i.climber.Record(hit)
+	if !ok {
+		return
+	}
+	i.adjustLock.Lock()
+	i.pendingAdjust += adjust
+	i.adjustLock.Unlock()
+	select {
+	case i.adjustCh <- struct{}{}:
+	default:
 	}
 }
 
+// adjustWindow 应用攒下的调整，window与eden、evict的上限一起改变，三者之和保持为size。
+// 先调整eden、evict再调整window：window变大时腾出的位置先让出来，window变小时溢出的元素有地方去。
+// 只有cleanWindowZone会把元素移出window，两步之间元素总数不会超过size
+func (i *iheEvict) adjustWindow() {
+	i.adjustLock.Lock()
+	adjust := i.pendingAdjust
+	i.pendingAdjust = 0
+	i.adjustLock.Unlock()
+
+	i.winRatio = tinylfu.ClampWindowRatio(i.winRatio + adjust)
+	winSize := windowSize(i.size, i.winRatio)
+	edenSize, evictSize := mainSizes(i.size, winSize)
+
+	// evict放不下的直接淘汰，eden放不下的与checkUnderEden一样降入evict
+	if keys := i.evictZone.SetMaxItems(evictSize); len(keys) > 0 {
+		i.sendEvict(keys)
+	}
+	for _, key := range i.edenZone.SetMaxItems(edenSize) {
+		atomic.AddInt64(&total, int64(-(i.estimate(key))))
+		atomic.AddInt64(&totalCount, -1)
+		i.backwardIntoEvict(key)
+	}
+
+	i.winLock.Lock()
+	arr := i.winZone.Resize(winSize)
+	i.winSafeSizeThreshold = int(float64(winSize) * defaultWinSafeRatio)
+	i.winLock.Unlock()
+	i.leaveWindowZone(arr)
+}
+
+// Len 各区域记录的key个数之和，包括已从缓存删除但仍留在区域中的key
+func (i *iheEvict) Len() int {
+	i.winLock.RLock()
+	n := i.winZone.Size()
+	i.winLock.RUnlock()
+	return n + i.edenZone.Len() + i.evictZone.Len()
+}
+
 func (i *iheEvict) advanceIntoEden(key string) {
-	atomic.AddInt64(&total, int64(i.cms.Estimate(key)))
+	atomic.AddInt64(&total, int64(i.estimate(key)))
 	atomic.AddInt64(&totalCount, 1)
 
 	err := i.edenZone.Add(key)
@@ -229,24 +358,28 @@ func (i *iheEvict) advanceIntoEden(key string) {
 	i.notifyCleanEdenZone()
 
 	// 真这么倒霉就全部丢弃吧
-	i.evictNotify <- []string{key}
+	i.sendEvict([]string{key})
 }
 
 func (i *iheEvict) backwardIntoEvict(key string) {
 	if i.evictZone.IsFull() {
-		r := i.evictZone.Reset()
-		i.evictNotify <- r
+		i.sendEvict(i.evictZone.Reset())
 	}
-	err := i.evictZone.Add(key)
-	if err == ErrFull {
-		r := i.evictZone.Reset()
-		i.evictNotify <- r
+	// 清空后仍放不下(evict上限为0)时key一并淘汰，不能留下不在任何区域中的key
+	if err := i.evictZone.Add(key); err != nil {
+		i.sendEvict(append(i.evictZone.Reset(), key))
 	}
 }
 
 func (i *iheEvict) cleanEdenZonePeriodically() {
-	for range i.edenCleanTicker.C {
-		i.notifyCleanEdenZone()
+	defer i.wg.Done()
+	for {
+		select {
+		case <-i.edenCleanTicker.C:
+			i.notifyCleanEdenZone()
+		case <-i.done:
+			return
+		}
 	}
 }
 
@@ -261,13 +394,19 @@ func (i *iheEvict) notifyCleanEdenZone() {
 }
 
 func (i *iheEvict) cleanEdenZone() {
-	for range i.edenCleanCh {
-		i.edenZone.Clean()
+	defer i.wg.Done()
+	for {
+		select {
+		case <-i.edenCleanCh:
+			i.edenZone.Clean()
+		case <-i.done:
+			return
+		}
 	}
 }
 
 func (i *iheEvict) checkUnderEden(key string) bool {
-	c := float64(i.cms.Estimate(key))
+	c := float64(i.estimate(key))
 	count := float64(atomic.LoadInt64(&totalCount))
 	var threshold float64
 	if count != 0 {
@@ -276,7 +415,7 @@ func (i *iheEvict) checkUnderEden(key string) bool {
 
 	if c < threshold {
 		// move to evict
-		atomic.AddInt64(&total, int64(-(i.cms.Estimate(key))))
+		atomic.AddInt64(&total, int64(-(i.estimate(key))))
 		atomic.AddInt64(&totalCount, -1)
 		i.backwardIntoEvict(key)
 		return true
@@ -285,7 +424,7 @@ func (i *iheEvict) checkUnderEden(key string) bool {
 }
 
 func (i *iheEvict) checkReachEvict(key string) bool {
-	c := float64(i.cms.Estimate(key))
+	c := float64(i.estimate(key))
 	var threshold float64
 	count := float64(atomic.LoadInt64(&totalCount))
 	if count == 0 {
@@ -302,7 +441,9 @@ func (i *iheEvict) checkReachEvict(key string) bool {
 		x := rand.Intn(defaultEvictPercentage)
 		// 如果等于0，那么自然应该删除。可是在其他情况下应该如何删除呢？随机？阈值？时间？
 		if c == 0 || x == 1 {
-			i.evictNotify <- []string{key}
+			// 已淘汰的key不再占evict的位置
+			i.sendEvict([]string{key})
+			return true
 		}
 	}
 	return false
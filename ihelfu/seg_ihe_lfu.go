@@ -1,31 +1,106 @@
 package ihelfu
 
-import "sync"
+import (
+	"context"
+	"learn/ihe-lru"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-type segIheLfu struct {
-	ie          *iheEvict
-	cache       map[string]int64
+type segIheLfu[V any] struct {
+	ie    *iheEvict
+	cache map[string]*lfuItem[V]
+	// removed 已删除、过期但记录仍在某个区域中的key。重新插入时沿用这条记录，每个key在各区域中最多一条记录，
+	// 旧记录被淘汰时不会删掉重新插入的元素。与cache一起由lock保护
+	removed     map[string]struct{}
 	lock        *sync.RWMutex
 	evictNotify chan []string
+	onEvict     func(key string, value V, reason ihe_lru.EvictReason)
+
+	expireAfterWrite  time.Duration
+	expireAfterAccess time.Duration
+	now               func() time.Time
+	expireTicker      *time.Ticker
+
+	loads ihe_lru.LoadGroup[string, V]
+	stats ihe_lru.StatsCounter
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type lfuItem[V any] struct {
+	key   string
+	value V
+	// 过期时间，UnixNano，0表示不过期。expireAt可能被Get并发顺延，需atomic读写
+	writeDeadline int64
+	expireAt      int64
 }
 
 const (
-	defaultEvictRatio = 4
+	defaultExpireInterval = time.Second
 )
 
-func (s *segIheLfu) Get(key string) (int64, bool) {
+func (s *segIheLfu[V]) Get(key string) (V, bool) {
+	v, ok := s.get(key)
+	// RecordAccess不阻塞，window大小由iheEvict的后台goroutine调整
+	s.ie.RecordAccess(ok)
+	return v, ok
+}
+
+func (s *segIheLfu[V]) get(key string) (V, bool) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
-	v, ok := s.cache[key]
+	i, ok := s.cache[key]
 	if !ok {
-		return -1, false
+		s.stats.RecordMiss()
+		var zero V
+		return zero, false
+	}
+	// 过期元素视为不存在，等待后台清理
+	if exp := atomic.LoadInt64(&i.expireAt); exp != 0 {
+		now := s.now().UnixNano()
+		if exp <= now {
+			s.stats.RecordMiss()
+			var zero V
+			return zero, false
+		}
+		if s.expireAfterAccess > 0 {
+			atomic.StoreInt64(&i.expireAt, minDeadline(i.writeDeadline, now+int64(s.expireAfterAccess)))
+		}
 	}
-	s.ie.UpdateAccessCount(key)
-	return v, true
+	s.stats.RecordHit()
+	// 缓存中的key已在某个区域中，只记录访问，再放入window会多出一条记录
+	s.ie.increment(key)
+	return i.value, true
+}
+
+// GetOrLoad 命中直接返回，未命中时调用loader加载并插入。同一key并发未命中只会执行一次loader，错误不会被缓存
+func (s *segIheLfu[V]) GetOrLoad(ctx context.Context, key string, loader ihe_lru.Loader[string, V]) (V, error) {
+	if v, ok := s.Get(key); ok {
+		return v, nil
+	}
+	return s.loads.Do(ctx, key, func(ctx context.Context) (V, error) {
+		v, err := loader(ctx, key)
+		s.stats.RecordLoad(err)
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+		s.Insert(key, v)
+		return v, nil
+	})
 }
 
-func (s *segIheLfu) Insert(key string, value int64) {
+func (s *segIheLfu[V]) Insert(key string, value V) {
+	s.InsertWithTTL(key, value, s.expireAfterWrite)
+}
+
+// InsertWithTTL 同Insert，且元素在ttl后过期，ttl为0表示不过期
+func (s *segIheLfu[V]) InsertWithTTL(key string, value V, ttl time.Duration) {
 	// 似乎是这个地方堵死，导致goroutine一直创建却没有回收
 	s.lock.RLock()
 
@@ -36,37 +111,217 @@ func (s *segIheLfu) Insert(key string, value int64) {
 	}
 
 	s.lock.RUnlock()
-	if s.ie.UpdateAccessCount(key) {
-		// 如果更新不成功，岂不是永远解锁不了啊
-		s.lock.Lock()
-		s.cache[key] = value
+	i := &lfuItem[V]{
+		key:   key,
+		value: value,
+	}
+	s.setDeadline(i, ttl)
+	// 持有锁放入缓存与window，离开window时key一定已在缓存中，淘汰不会漏掉
+	s.lock.Lock()
+	if _, ok = s.cache[key]; ok {
 		s.lock.Unlock()
+		return
+	}
+	s.cache[key] = i
+	if _, ok = s.removed[key]; ok {
+		// 沿用删除前的记录
+		delete(s.removed, key)
+		s.ie.increment(key)
+		s.lock.Unlock()
+		return
+	}
+	admitted := s.ie.Admit(key)
+	if !admitted {
+		// window已满未通过准入
+		delete(s.cache, key)
+	}
+	s.lock.Unlock()
+	if !admitted {
+		s.notifyEvict([]*lfuItem[V]{i}, ihe_lru.EvictByCapacity)
 	}
 }
 
-func (s *segIheLfu) evict() {
-	for keys := range s.evictNotify {
-		s.lock.Lock()
-		for _, key := range keys {
+// Set 添加或更新key对应元素。key已存在时直接替换value，不存在时同Insert，未通过准入则以EvictByCapacity通知
+func (s *segIheLfu[V]) Set(key string, value V) {
+	s.lock.Lock()
+	old, ok := s.cache[key]
+	if ok {
+		i := &lfuItem[V]{
+			key:   key,
+			value: value,
+		}
+		s.setDeadline(i, s.expireAfterWrite)
+		s.cache[key] = i
+	}
+	s.lock.Unlock()
+	if ok {
+		s.notifyEvict([]*lfuItem[V]{old}, ihe_lru.EvictByReplace)
+		return
+	}
+	s.Insert(key, value)
+}
+
+// Remove 删除key对应元素，返回是否存在。key的记录仍留在区域中，见removed
+func (s *segIheLfu[V]) Remove(key string) bool {
+	s.lock.Lock()
+	i, ok := s.cache[key]
+	if ok {
+		delete(s.cache, key)
+		s.removed[key] = struct{}{}
+	}
+	s.lock.Unlock()
+	if ok {
+		s.notifyEvict([]*lfuItem[V]{i}, ihe_lru.EvictByRemove)
+	}
+	return ok
+}
+
+func (s *segIheLfu[V]) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.cache)
+}
+
+// Close 先停止iheEvict，再处理完已通知的驱逐并停止过期清理，等待全部goroutine退出，可重复调用
+func (s *segIheLfu[V]) Close() error {
+	s.closeOnce.Do(func() {
+		s.ie.Close()
+		s.expireTicker.Stop()
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+func (s *segIheLfu[V]) evict() {
+	defer s.wg.Done()
+	for {
+		select {
+		case keys := <-s.evictNotify:
+			s.evictKeys(keys)
+		case <-s.expireTicker.C:
+			s.removeExpired()
+		case <-s.done:
+			for {
+				select {
+				case keys := <-s.evictNotify:
+					s.evictKeys(keys)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// setDeadline 按ttl与访问过期设置新元素的过期时间，ttl为0表示不过期
+func (s *segIheLfu[V]) setDeadline(i *lfuItem[V], ttl time.Duration) {
+	if ttl <= 0 && s.expireAfterAccess <= 0 {
+		return
+	}
+	now := s.now().UnixNano()
+	if ttl > 0 {
+		i.writeDeadline = now + int64(ttl)
+	}
+	i.expireAt = i.writeDeadline
+	if s.expireAfterAccess > 0 {
+		i.expireAt = minDeadline(i.writeDeadline, now+int64(s.expireAfterAccess))
+	}
+}
+
+func (s *segIheLfu[V]) evictKeys(keys []string) {
+	var evicted []*lfuItem[V]
+	s.lock.Lock()
+	for _, key := range keys {
+		// 已删除的key，记录离开区域后不再保留
+		if _, ok := s.removed[key]; ok {
+			delete(s.removed, key)
+			continue
+		}
+		i, ok := s.cache[key]
+		if !ok {
+			continue
+		}
+		delete(s.cache, key)
+		evicted = append(evicted, i)
+	}
+	s.lock.Unlock()
+
+	s.notifyEvict(evicted, ihe_lru.EvictByCapacity)
+}
+
+// removeExpired 元素没有顺序，只能遍历全部元素清理
+func (s *segIheLfu[V]) removeExpired() {
+	now := s.now().UnixNano()
+	var expired []*lfuItem[V]
+	s.lock.Lock()
+	for key, i := range s.cache {
+		if exp := atomic.LoadInt64(&i.expireAt); exp != 0 && exp <= now {
 			delete(s.cache, key)
+			s.removed[key] = struct{}{}
+			expired = append(expired, i)
 		}
-		s.lock.Unlock()
 	}
+	s.lock.Unlock()
+
+	s.notifyEvict(expired, ihe_lru.EvictByExpire)
+}
+
+// Stats 返回命中、驱逐等统计。元素没有重量，Weight即元素个数
+func (s *segIheLfu[V]) Stats() ihe_lru.Stats {
+	s.lock.RLock()
+	size := len(s.cache)
+	s.lock.RUnlock()
+	return s.stats.Snapshot(size, int64(size))
 }
 
-func NewSegIheLfu(size int) (*segIheLfu, error) {
+func (s *segIheLfu[V]) notifyEvict(items []*lfuItem[V], reason ihe_lru.EvictReason) {
+	for _, i := range items {
+		s.stats.RecordEviction(reason)
+		if s.onEvict != nil {
+			s.onEvict(i.key, i.value, reason)
+		}
+	}
+}
+
+func NewSegIheLfu(size int) (*segIheLfu[int64], error) {
+	return NewSegIheLfuWithOptions(size, Options[int64]{})
+}
+
+func NewSegIheLfuWithOptions[V any](size int, opts Options[V]) (*segIheLfu[V], error) {
 	en := make(chan []string, size/10+1)
-	ie, err := NewIheEvict(int64(size*defaultEvictRatio), en)
+	// 各区域记录的key总数不超过size，缓存中的key都在某个区域中，元素个数也就不超过size
+	ie, err := NewIheEvict(int64(size), en)
 	if err != nil {
 		return nil, err
 	}
-	u := &segIheLfu{
+	u := &segIheLfu[V]{
 		ie:          ie,
-		cache:       make(map[string]int64, size),
+		cache:       make(map[string]*lfuItem[V], size),
+		removed:     make(map[string]struct{}),
 		lock:        &sync.RWMutex{},
 		evictNotify: en,
+		onEvict:     opts.OnEvict,
+
+		expireAfterWrite:  opts.ExpireAfterWrite,
+		expireAfterAccess: opts.ExpireAfterAccess,
+		now:               opts.now(),
+		expireTicker:      time.NewTicker(defaultExpireInterval),
+		done:              make(chan struct{}),
 	}
 
+	u.wg.Add(1)
 	go u.evict()
 	return u, nil
 }
+
+// minDeadline 返回更早的过期时间，0表示不过期
+func minDeadline(a, b int64) int64 {
+	if a == 0 {
+		return b
+	}
+	if b == 0 || a < b {
+		return a
+	}
+	return b
+}
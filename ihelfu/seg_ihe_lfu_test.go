@@ -2,7 +2,9 @@ package ihelfu
 
 import (
 	"fmt"
-	"learn/tool/fuzz"
+	"learn/ihe-lru"
+	"learn/ihe-lru/cachetest"
+	"learn/ihe-lru/workload"
 	"math/rand"
 	"runtime"
 	"sync"
@@ -27,6 +29,97 @@ func TestSegIheLfuBasicUse(t *testing.T) {
 	}
 }
 
+func TestSegIheLfuTTL(t *testing.T) {
+	// 后台清理goroutine同样会读取时间
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	u, err := NewSegIheLfuWithOptions(10, Options[int64]{
+		Now: func() time.Time {
+			return time.Unix(0, now.Load())
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.InsertWithTTL("a", 1, time.Second)
+	if _, ok := u.Get("a"); !ok {
+		t.Fatal("has a indeed")
+	}
+	now.Add(int64(2 * time.Second))
+	if _, ok := u.Get("a"); ok {
+		t.Fatal("a should be expired")
+	}
+}
+
+// TestSegIheLfuRemoveReinsert 删除后重新插入的key沿用原来的记录，这条记录被淘汰时删除重新插入的元素，
+// 不会留下另一条旧记录之后再把它淘汰
+func TestSegIheLfuRemoveReinsert(t *testing.T) {
+	var mu sync.Mutex
+	var evicted []string
+	u, err := NewSegIheLfuWithOptions(100, Options[int64]{
+		OnEvict: func(key string, value int64, reason ihe_lru.EvictReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			evicted = append(evicted, fmt.Sprintf("%s=%d %v", key, value, reason))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { u.Close() })
+	u.Insert("a", 1)
+	u.Remove("a")
+	u.Insert("a", 2)
+	if n := u.ie.Len(); n != 1 {
+		t.Fatalf("want 1 record for a, got %d", n)
+	}
+	if v, ok := u.Get("a"); !ok || v != 2 {
+		t.Fatalf("want a=2, got %d %v", v, ok)
+	}
+	if n := u.ie.Len(); n != 1 {
+		t.Fatalf("want 1 record for a after Get, got %d", n)
+	}
+	// 唯一的记录被淘汰
+	u.evictKeys([]string{"a"})
+	if _, ok := u.Get("a"); ok {
+		t.Fatal("a should be evicted")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		fmt.Sprintf("a=1 %v", ihe_lru.EvictByRemove),
+		fmt.Sprintf("a=2 %v", ihe_lru.EvictByCapacity),
+	}
+	if fmt.Sprint(evicted) != fmt.Sprint(want) {
+		t.Fatalf("want evictions %v, got %v", want, evicted)
+	}
+}
+
+func TestSegIheLfuClose(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	u, err := NewSegIheLfu(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range workload.Keys(workload.NewZipf(1, 1000, 0.99), 10000) {
+		if _, ok := u.Get(k); !ok {
+			u.Insert(k, 1)
+		}
+	}
+	if err = u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close后的访问不能阻塞
+	for _, k := range workload.Keys(workload.NewUniform(1, 1000), 1000) {
+		if _, ok := u.Get(k); !ok {
+			u.Insert(k, 1)
+		}
+	}
+}
+
 var miss = int64(0)
 
 func TestBenchSegIheLfu(t *testing.T) {
@@ -39,13 +132,15 @@ func TestBenchSegIheLfu(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// 100个key上的zipf分布，生成器不是线程安全的，先生成好
+	keys := workload.Keys(workload.NewZipf(1, 100, 0.99), count)
 	wg := &sync.WaitGroup{}
 	wg.Add(count)
 	var ms runtime.MemStats
 	for i := 0; i < count; i++ {
 		if i%10 != 0 {
 			go func() {
-				k := fuzz.GenerateRandStringFixedNumber(2)
+				k := keys[i]
 				if _, ok := u.Get(k); !ok {
 					atomic.AddInt64(&miss, 1)
 					u.Insert(k, int64(i))
@@ -55,7 +150,7 @@ func TestBenchSegIheLfu(t *testing.T) {
 			}()
 		} else {
 			go func() {
-				u.Insert(fuzz.GenerateRandStringFixedNumber(2), int64(i))
+				u.Insert(keys[i], int64(i))
 				wg.Done()
 			}()
 		}
@@ -1,7 +1,5 @@
 package k_lru_concurrent
 
-import "learn/tool/timeCost"
-
 // top-K: 要找到一定区间内小id。
 // 1. 堆很棒。删除时相对来说比较快，可是会稍微耽误一点更新时间
 // 2. 快排。可
@@ -13,6 +11,7 @@ type accessCount struct {
 type Acm struct {
 	counts map[string]*accessCount
 	k      int
+	trace  trace
 }
 
 type compareItem struct {
@@ -54,7 +53,7 @@ func (m *Acm) Delete(key string) {
 }
 
 func (m *Acm) TopKByQuickSort() []*compareItem {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.TopKByQuickSort)()
+	defer m.trace.record(TopKByQuickSort, m.trace.start())
 	l := len(m.counts)
 	if l <= m.k {
 		return nil
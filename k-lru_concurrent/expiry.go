@@ -0,0 +1,50 @@
+package k_lru_concurrent
+
+import "time"
+
+const defaultExpireInterval = time.Second
+
+// expiryHeap 按heapDeadline排列的小顶堆，堆顶为最先过期的元素。只在持有写锁时访问
+// Get顺延过期时间时并不会调整堆，清理时发现堆顶实际未过期再重新调整位置
+type expiryHeap[V any] []*item[V]
+
+func (h expiryHeap[V]) Len() int {
+	return len(h)
+}
+
+func (h expiryHeap[V]) Less(i, j int) bool {
+	return h[i].heapDeadline < h[j].heapDeadline
+}
+
+func (h expiryHeap[V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[V]) Push(x any) {
+	i := x.(*item[V])
+	i.index = len(*h)
+	*h = append(*h, i)
+}
+
+func (h *expiryHeap[V]) Pop() any {
+	old := *h
+	n := len(old)
+	i := old[n-1]
+	old[n-1] = nil
+	i.index = -1
+	*h = old[:n-1]
+	return i
+}
+
+// minDeadline 返回更早的过期时间，0表示不过期
+func minDeadline(a, b int64) int64 {
+	if a == 0 {
+		return b
+	}
+	if b == 0 || a < b {
+		return a
+	}
+	return b
+}
@@ -1,61 +1,560 @@
 package k_lru_concurrent
 
 import (
+	"context"
 	"fmt"
 	"learn/ihe-lru"
-	"learn/tool/timeCost"
+	"learn/ihe-lru/cachetest"
 	"math/rand"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// TestBasicUseKLru 清理在后台进行，新元素在栈底先被清理；访问超过K次的元素才移到栈顶
 func TestBasicUseKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
 	size := 2
-	ch := make(chan string, size/2)
-	l := NewConcurrentLRU(size, ch)
-	u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*5, size*10)
-	go u.Run()
+	l := newTestKLRU(t, KLRUOptions[string]{Size: size})
 	// get un-exists item
-	v, ok := l.Get("hello")
-	if ok {
+	if _, ok := l.Get("hello"); ok {
 		t.Fatal("should no match item")
 	}
 
 	// add hello world
 	l.Add("hello", "world")
-
-	// get exists
-	v, ok = l.Get("hello")
-	if !ok {
+	if v, ok := l.Get("hello"); !ok || v != "world" {
 		t.Fatal("should has hello")
 	}
 
-	// add exceeded item to ensure evict fine
+	// add exceeded item to ensure evict fine，very在栈底，先被清理
 	l.Add("good", "kangkang")
 	l.Add("very", "well")
-	v, ok = l.Get("hello")
-	if ok {
-		t.Fatal("should has no hello")
+	waitUntilLen(l, size)
+	if n := l.Len(); n != size {
+		t.Fatalf("want len %d, got %d", size, n)
+	}
+	if _, ok := l.Get("very"); ok {
+		t.Fatal("should has no very")
+	}
+	if _, ok := l.Get("hello"); !ok {
+		t.Fatal("should has hello")
 	}
 
 	// update good to microsoft
 	l.Add("good", "microsoft")
-	v, ok = l.Get("good")
-	if !ok || v != "microsoft" {
+	if v, ok := l.Get("good"); !ok || v != "microsoft" {
 		t.Fatal("update failed")
 	}
 
-	// access good will result top good
-	l.Get("good")
-	l.Get("good")
-	if l.evictList.Front().Value.(*item).key != "good" {
-		t.Fatal("should good top")
+	// access good will result top good，访问计数由updater异步更新
+	front := func() string {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return l.evictList.Front().Value.(*item[string]).key
+	}
+	for i := 0; i < 1000 && front() != "good"; i++ {
+		l.Get("good")
+		time.Sleep(time.Millisecond)
+	}
+	if key := front(); key != "good" {
+		t.Fatalf("should good top, got %s", key)
+	}
+}
+
+type evictEvent struct {
+	key    string
+	value  string
+	reason ihe_lru.EvictReason
+}
+
+func TestOnEvictKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	evs := make(chan evictEvent, 10)
+	onEvict := func(key, value string, reason ihe_lru.EvictReason) {
+		evs <- evictEvent{key: key, value: value, reason: reason}
+	}
+	size := 2
+	ls := []getAdder{
+		newTestKLRU(t, KLRUOptions[string]{Size: size, Options: Options[string]{OnEvict: onEvict}}),
+		newTestCLRU(t, size, Options[string]{OnEvict: onEvict}),
+	}
+
+	for _, l := range ls {
+		l.Add("hello", "world")
+		// clru添加是异步的，等待添加生效
+		waitUntilExists(l, "hello")
+		l.Add("hello", "kangkang")
+		ev := <-evs
+		if ev.key != "hello" || ev.value != "world" || ev.reason != ihe_lru.EvictByReplace {
+			t.Fatalf("should replace hello world, got %v", ev)
+		}
+
+		for i := 0; i < size*2; i++ {
+			key := strconv.Itoa(i)
+			l.Add(key, key)
+			waitUntilExists(l, key)
+		}
+		select {
+		case ev = <-evs:
+			if ev.reason != ihe_lru.EvictByCapacity {
+				t.Fatalf("should evict by capacity, got %v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("should evict one item")
+		}
+		for len(evs) > 0 {
+			<-evs
+		}
+	}
+}
+
+func waitUntilExists(l getAdder, key string) {
+	for i := 0; i < 100; i++ {
+		if _, ok := l.Get(key); ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTTLKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	clock := cachetest.NewFakeClock()
+	evs := make(chan evictEvent, 10)
+	l := newTestKLRU(t, KLRUOptions[string]{Size: 10, Options: Options[string]{
+		ExpireAfterAccess: time.Minute,
+		Now:               clock.Now,
+		OnEvict: func(key, value string, reason ihe_lru.EvictReason) {
+			evs <- evictEvent{key: key, value: value, reason: reason}
+		},
+	}})
+	l.Add("hot", "1")
+	l.AddWithTTL("token", "2", time.Second)
+
+	clock.Advance(40 * time.Second)
+	if _, ok := l.Get("token"); ok {
+		t.Fatal("token should be expired")
+	}
+	if _, ok := l.Get("hot"); !ok {
+		t.Fatal("hot should not be expired")
+	}
+	clock.Advance(40 * time.Second)
+	if _, ok := l.Get("hot"); !ok {
+		t.Fatal("hot should be kept alive by access")
+	}
+
+	select {
+	case ev := <-evs:
+		if ev.key != "token" || ev.reason != ihe_lru.EvictByExpire {
+			t.Fatalf("token should be reclaimed, got %v", ev)
+		}
+	case <-time.After(3 * defaultExpireInterval):
+		t.Fatal("expired token should be reclaimed in background")
+	}
+}
+
+func TestWeightKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	rejected := make(chan string, 1)
+	opts := Options[string]{
+		Weigher: func(key, value string) int64 {
+			return int64(len(value))
+		},
+		MaxWeight: 100,
+		OnEvict: func(key, value string, reason ihe_lru.EvictReason) {
+			if key == "huge" && reason == ihe_lru.EvictByCapacity {
+				rejected <- key
+			}
+		},
+	}
+	l := newTestKLRU(t, KLRUOptions[string]{Options: opts})
+	for i := 0; i < 10; i++ {
+		l.Add(strconv.Itoa(i), strings.Repeat("x", 30))
+	}
+	for i := 0; i < 100 && atomic.LoadInt64(&l.weight) > l.maxWeight; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if w := atomic.LoadInt64(&l.weight); w > l.maxWeight {
+		t.Fatalf("weight %d should not exceed max weight", w)
+	}
+
+	l.Add("huge", strings.Repeat("x", 101))
+	if _, ok := l.Get("huge"); ok {
+		t.Fatal("huge should be rejected")
+	}
+	if <-rejected != "huge" {
+		t.Fatal("huge should be evicted by capacity")
+	}
+}
+
+func TestResizeKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	l := newTestKLRU(t, KLRUOptions[string]{Size: 8})
+	for i := 0; i < 8; i++ {
+		l.Add(strconv.Itoa(i), "v")
+	}
+	l.Resize(4)
+	l.mu.RLock()
+	n := l.evictList.Len()
+	l.mu.RUnlock()
+	if n > 4 {
+		t.Fatalf("shrink should evict to 4, got %d", n)
+	}
+
+	l.Resize(16)
+	for i := 0; i < 12; i++ {
+		l.Add(strconv.Itoa(i), "v")
+	}
+	l.mu.RLock()
+	n = l.evictList.Len()
+	l.mu.RUnlock()
+	if n != 12 {
+		t.Fatalf("grow should hold 12, got %d", n)
+	}
+}
+
+func TestGetOrLoadKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	l := newTestKLRU(t, KLRUOptions[string]{Size: 10})
+	var calls int32
+	loader := func(ctx context.Context, key string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return "world", nil
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			if v, err := l.GetOrLoad(context.Background(), "hello", loader); err != nil || v != "world" {
+				t.Errorf("unexpected result %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("loader should run once, got %d", calls)
+	}
+	if s := l.Stats(); s.Loads != 1 || s.Misses < 1 {
+		t.Fatalf("bad loads %d misses %d", s.Loads, s.Misses)
+	}
+}
+
+func TestStatsKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	size := 4
+	ls := []klru{
+		newTestKLRU(t, KLRUOptions[string]{Size: size}),
+		newTestCLRU(t, size, Options[string]{}),
+	}
+
+	for _, l := range ls {
+		for i := 0; i < size*2; i++ {
+			key := strconv.Itoa(i)
+			l.Add(key, key)
+			waitUntilExists(l, key)
+		}
+		l.Get("missing")
+
+		var s ihe_lru.Stats
+		for i := 0; i < 100; i++ {
+			if s = l.Stats(); s.Evictions[ihe_lru.EvictByCapacity] > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		// clru添加是异步的，waitUntilExists可能先未命中几次
+		if s.Hits == 0 || s.Misses < 1 {
+			t.Fatalf("bad hits %d misses %d", s.Hits, s.Misses)
+		}
+		if s.Evictions[ihe_lru.EvictByCapacity] == 0 || s.Size == 0 {
+			t.Fatalf("should evict by capacity, evictions %v size %d", s.Evictions, s.Size)
+		}
+	}
+}
+
+func TestTracerKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	tracer := NewCostTracer()
+	l := newTestKLRU(t, KLRUOptions[string]{Size: 10, Options: Options[string]{Tracer: tracer}})
+	l.Add("hello", "world")
+	l.Get("hello")
+	l.Get("missing")
+
+	if n, _ := tracer.Cost(AddItem); n != 1 {
+		t.Fatalf("should trace 1 add, got %d", n)
+	}
+	if n, _ := tracer.Cost(GetItem); n != 2 {
+		t.Fatalf("should trace 2 get, got %d", n)
+	}
+	if n, _ := tracer.Cost(AcquireAddItemLock); n != 1 {
+		t.Fatalf("should trace add lock, got %d", n)
+	}
+}
+
+func TestCloseKLru(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	size := 10
+	ch := make(chan string, size*3)
+	l := NewConcurrentLRU(size, ch)
+	u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*2, size*5)
+	u.Run()
+	cch := make(chan string, size*3)
+	c := NewCLRU(size, cch)
+	cu := NewRecentUseUpdater(2, cch, c.MoveToFront, size*2, size*5)
+	cu.Run()
+	for i := 0; i < size*10; i++ {
+		key := strconv.Itoa(i % (size * 2))
+		l.Add(key, key)
+		l.Get(key)
+		c.Add(key, key)
+		c.Get(key)
+	}
+
+	// 先关闭缓存再关闭updater，重复Close没有影响
+	for _, closer := range []interface{ Close() error }{l, u, c, cu, l, c} {
+		if err := closer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close后的访问不能阻塞
+	for i := 0; i < size*10; i++ {
+		l.Get("0")
+		c.Get("0")
+		c.Add(strconv.Itoa(i), "v")
+	}
+}
+
+// klru lruConcurrent、clru共同的方法
+type klru interface {
+	getAdder
+	TryAdd(key, value string) error
+	Remove(key string) bool
+	MoveToFront(key string)
+	Len() int
+	Stats() ihe_lru.Stats
+	Close() error
+}
+
+// newKLrus 按实现名返回构造函数，用同样的配置测试两种实现
+func newKLrus(size int, opts Options[string]) map[string]func(ch chan string) klru {
+	return map[string]func(ch chan string) klru{
+		"lruConcurrent": func(ch chan string) klru { return NewConcurrentLRUWithOptions(size, ch, opts) },
+		"clru":          func(ch chan string) klru { return NewCLRUWithOptions(size, ch, opts) },
+	}
+}
+
+// newTestKLRU 通过NewKLRU创建，测试结束时连同updater一起Close。检查泄露的测试应先调用CheckLeaks
+func newTestKLRU(t testing.TB, opts KLRUOptions[string]) *kLRU[string] {
+	t.Helper()
+	l, err := NewKLRU(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// newTestAsyncKLRU 同newTestKLRU，通过NewAsyncKLRU创建
+func newTestAsyncKLRU(t testing.TB, opts KLRUOptions[string]) *asyncKLRU[string] {
+	t.Helper()
+	l, err := NewAsyncKLRU(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// newTestCLRU 同newTestAsyncKLRU，其余配置取默认值
+func newTestCLRU(t testing.TB, size int, opts Options[string]) klru {
+	t.Helper()
+	return newTestAsyncKLRU(t, KLRUOptions[string]{Size: size, Options: opts})
+}
+
+// TestRaceKLru 并发增删改查同一批key，配合-race运行。结束后元素个数与总重量应一致
+func TestRaceKLru(t *testing.T) {
+	size := 10
+	for name, newKLru := range newKLrus(size, Options[string]{}) {
+		t.Run(name, func(t *testing.T) {
+			ch := make(chan string, size*3)
+			l := newKLru(ch)
+			u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*2, size*5)
+			u.Run()
+			defer u.Close()
+
+			wg := &sync.WaitGroup{}
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 2000; i++ {
+						key := strconv.Itoa(rand.Intn(size * 2))
+						switch i % 4 {
+						case 0, 1:
+							l.Add(key, key)
+						case 2:
+							if v, ok := l.Get(key); ok && v != key {
+								t.Errorf("want %s, got %s", key, v)
+							}
+						default:
+							l.Remove(key)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			// Close后后台已处理完全部操作
+			l.Close()
+			st := l.Stats()
+			if st.Weight != int64(st.Size) {
+				t.Fatalf("want weight %d, got %d", st.Size, st.Weight)
+			}
+		})
 	}
 }
 
+// TestMaxSizeKLru 添加远快于后台清理时，元素个数也不超过MaxSize
+func TestMaxSizeKLru(t *testing.T) {
+	size, maxSize := 10, 12
+	// 回调中让出CPU，后台清理跟不上添加
+	onEvict := func(key, value string, reason ihe_lru.EvictReason) {
+		runtime.Gosched()
+	}
+	for name, newKLru := range newKLrus(size, Options[string]{MaxSize: maxSize, OnEvict: onEvict}) {
+		t.Run(name, func(t *testing.T) {
+			l := newKLru(make(chan string, size*3))
+			defer l.Close()
+
+			wg := &sync.WaitGroup{}
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 500; i++ {
+						key := strconv.Itoa(g*500 + i)
+						if err := l.TryAdd(key, key); err != nil {
+							t.Errorf("OverflowEvict should not fail, got %v", err)
+						}
+						if n := l.Len(); n > maxSize {
+							t.Errorf("want len <= %d, got %d", maxSize, n)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// TestOverflowKLru 达到MaxSize时三种Overflow的行为
+func TestOverflowKLru(t *testing.T) {
+	size := 4
+	fill := func(t *testing.T, l klru) {
+		for i := 0; i < size; i++ {
+			if err := l.TryAdd(strconv.Itoa(i), "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		for name, newKLru := range newKLrus(size, Options[string]{MaxSize: size, Overflow: OverflowReject}) {
+			t.Run(name, func(t *testing.T) {
+				l := newKLru(make(chan string, size*3))
+				defer l.Close()
+				fill(t, l)
+				if err := l.TryAdd("new", "v"); err != ErrFull {
+					t.Fatalf("want ErrFull, got %v", err)
+				}
+				if n := l.Stats().Rejections; n != 1 {
+					t.Fatalf("want 1 rejection, got %d", n)
+				}
+				// 拒绝时已通知后台清理，之后可以添加
+				var err error
+				for i := 0; i < 100; i++ {
+					if err = l.TryAdd("new", "v"); err == nil {
+						break
+					}
+					time.Sleep(time.Millisecond)
+				}
+				if err != nil {
+					t.Fatalf("want added after eviction, got %v", err)
+				}
+				if n := l.Len(); n > size {
+					t.Fatalf("want len <= %d, got %d", size, n)
+				}
+			})
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		// 驱逐回调阻塞到release关闭，后台清理随之卡住
+		var release chan struct{}
+		onEvict := func(key, value string, reason ihe_lru.EvictReason) {
+			if reason == ihe_lru.EvictByCapacity {
+				<-release
+			}
+		}
+		opts := Options[string]{MaxSize: size, Overflow: OverflowBlock, OverflowTimeout: 50 * time.Millisecond, OnEvict: onEvict}
+		for name, newKLru := range newKLrus(size, opts) {
+			t.Run(name, func(t *testing.T) {
+				release = make(chan struct{})
+				l := newKLru(make(chan string, size*3))
+				defer l.Close()
+				fill(t, l)
+				// 后台清理出一个空位后卡在回调中
+				if err := l.TryAdd("a", "v"); err != nil {
+					t.Fatalf("want added after eviction, got %v", err)
+				}
+				start := time.Now()
+				if err := l.TryAdd("b", "v"); err != ErrFull {
+					t.Fatalf("want ErrFull after timeout, got %v", err)
+				}
+				if d := time.Since(start); d < 50*time.Millisecond {
+					t.Fatalf("want blocked for timeout, returned after %s", d)
+				}
+				if n := l.Stats().Rejections; n != 1 {
+					t.Fatalf("want 1 rejection, got %d", n)
+				}
+				close(release)
+				if err := l.TryAdd("b", "v"); err != nil {
+					t.Fatalf("want added after release, got %v", err)
+				}
+			})
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		for name, newKLru := range newKLrus(size, Options[string]{MaxSize: size}) {
+			t.Run(name, func(t *testing.T) {
+				l := newKLru(make(chan string, size*3))
+				defer l.Close()
+				fill(t, l)
+				for i := 0; i < size; i++ {
+					if err := l.TryAdd("new"+strconv.Itoa(i), "v"); err != nil {
+						t.Fatal(err)
+					}
+					if n := l.Len(); n > size {
+						t.Fatalf("want len <= %d, got %d", size, n)
+					}
+				}
+				if n := l.Stats().Rejections; n != 0 {
+					t.Fatalf("want no rejection, got %d", n)
+				}
+			})
+		}
+	})
+}
+
 // 1 BenchmarkWillPanicIfLotsAccess-8   	  622500	      2772 ns/opType
 // 2 BenchmarkWillPanicIfLotsAccess-8   	  543459	      3768 ns/opType 当我添加rlock去事先判断是否被删了，然后lock再取，再移到顶部可是效率反而更慢啦
 // 3 BenchmarkWillPanicIfLotsAccess-8   	  501883	      2700 ns/opType 而当我rLock判断是否存在，而后lock移到顶，效率稍微改良那么一点
@@ -64,23 +563,23 @@ func TestBasicUseKLru(t *testing.T) {
 // 6 BenchmarkWillPanicIfLotsAccess-8   	  476786	      2768 ns/opType 基于4，当将update count的chan长度从size/2 -> size*3，效率回到了3
 func BenchmarkWillPanicIfLotsAccess(b *testing.B) {
 	size := 5
-	ch := make(chan string, size*3)
-	l := NewConcurrentLRU(size, ch)
-	u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*5, size*10)
-	go u.Run()
+	l := newTestKLRU(b, KLRUOptions[string]{Size: size, LowThreshold: size * 5, HighThreshold: size * 10})
 
-	//wg := &sync.WaitGroup{}
-	//wg.Add(b.N)
+	// 等待全部访问结束再Close
+	wg := &sync.WaitGroup{}
+	wg.Add(b.N)
 	for i := 0; i < b.N; i++ {
-		go accessKLru(l)
+		go accessKLruWithWG(l, wg)
 	}
-	//
-	//wg.Wait()
+	wg.Wait()
 	//DefaultTimeCostAnalyzer.OutputResult()
 }
 
 const times = 1000000
 
+// maxInflight 同时存在的访问goroutine上限。-race最多允许8128个goroutine，clru的添加阻塞在ops上时goroutine会大量堆积
+const maxInflight = 1000
+
 // module: EvictUnusedItem cost: 42m25.095401144s
 // module: UpdateAccessCount cost: 527.232291ms
 // module: AddItem cost: 2h40m16.484104279s
@@ -152,36 +651,42 @@ const times = 1000000
 //module: AcquireEvictUnusedItemLock cost: 390.28537ms
 func TestCostTime(t *testing.T) {
 	size := 5
-	ch := make(chan string, size*3)
-	l := NewConcurrentLRU(size, ch)
-	u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*2, size*5)
-	go u.Run()
+	tracer := NewCostTracer()
+	l := newTestKLRU(t, KLRUOptions[string]{Size: size, Options: Options[string]{Tracer: tracer}})
 
 	wg := &sync.WaitGroup{}
 	wg.Add(times)
+	inflight := make(chan struct{}, maxInflight)
 	for i := 0; i < times; i++ {
-		go accessKLruWithWG(l, wg)
+		inflight <- struct{}{}
+		go func() {
+			accessKLruWithWG(l, wg)
+			<-inflight
+		}()
 	}
 
 	wg.Wait()
-	timeCost.DefaultTimeCostAnalyzer.OutputResult()
+	tracer.OutputResult()
 }
 
 func TestMgrCostTime(t *testing.T) {
 	size := 5
-	ch := make(chan string, size*3)
-	l := NewCLRU(size, ch)
-	u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*2, size*5)
-	go u.Run()
+	tracer := NewCostTracer()
+	l := newTestCLRU(t, size, Options[string]{Tracer: tracer})
 
 	wg := &sync.WaitGroup{}
 	wg.Add(times)
+	inflight := make(chan struct{}, maxInflight)
 	for i := 0; i < times; i++ {
-		go accessKLruWithWG(l, wg)
+		inflight <- struct{}{}
+		go func() {
+			accessKLruWithWG(l, wg)
+			<-inflight
+		}()
 	}
 
 	wg.Wait()
-	timeCost.DefaultTimeCostAnalyzer.OutputResult()
+	tracer.OutputResult()
 }
 
 var mismatchCount int
@@ -275,10 +780,8 @@ var hightThresholdRate = 5
 //module: EvictUnusedItem cost: 144.897955ms
 //miss rate 23611--- PASS: TestMissRate (0.27s)
 func TestMissRate(t *testing.T) {
-	ch := make(chan string, size*updateCountChRate)
-	l := NewConcurrentLRU(size, ch)
-	u := NewRecentUseUpdater(k, ch, l.MoveToFront, size*lowThresholdRate, size*hightThresholdRate)
-	go u.Run()
+	tracer := NewCostTracer()
+	l := newTestKLRU(t, missRateOptions(tracer))
 
 	initM := genFreqKeyValues(size, rate)
 	for k, v := range initM {
@@ -298,20 +801,34 @@ func TestMissRate(t *testing.T) {
 	}
 
 	wg.Wait()
-	go printMemStatRepeat(ms)
-	time.Sleep(10 * time.Second)
-	timeCost.DefaultTimeCostAnalyzer.OutputResult()
+	printMemStatRepeat(ms, 10)
+	tracer.OutputResult()
 	fmt.Printf("miss rate %d", mismatchCount)
 }
 
-func printMemStatRepeat(ms runtime.MemStats) {
+// printMemStatRepeat 每秒输出一次内存占用，共n次
+func printMemStatRepeat(ms runtime.MemStats, n int) {
 	tk := time.NewTicker(time.Second)
-	for range tk.C {
+	defer tk.Stop()
+	for i := 0; i < n; i++ {
+		<-tk.C
 		runtime.ReadMemStats(&ms)
 		fmt.Printf("mem alloc %d \n", byteToMB(ms.Alloc))
 	}
 }
 
+// missRateOptions TestMissRate、TestMgrMissRate共用的配置
+func missRateOptions(tracer Tracer) KLRUOptions[string] {
+	return KLRUOptions[string]{
+		Size:          size,
+		K:             k,
+		ChanSize:      size * updateCountChRate,
+		LowThreshold:  size * lowThresholdRate,
+		HighThreshold: size * hightThresholdRate,
+		Options:       Options[string]{Tracer: tracer},
+	}
+}
+
 func byteToMB(bs uint64) uint64 {
 	return bs / 1024 / 1024
 }
@@ -349,10 +866,8 @@ func byteToMB(bs uint64) uint64 {
 // 两者变得差不多啦。lock方式add更快，mgr evict更快点
 // 令我奇怪的是内存占用居然差不多，mgr 最高38M， lock最高 39M。 实际表现差不多。最后10s全部稳定在40M
 func TestMgrMissRate(t *testing.T) {
-	ch := make(chan string, size*updateCountChRate)
-	l := NewCLRU(size, ch)
-	u := NewRecentUseUpdater(k, ch, l.MoveToFront, size*lowThresholdRate, size*hightThresholdRate)
-	go u.Run()
+	tracer := NewCostTracer()
+	l := newTestAsyncKLRU(t, missRateOptions(tracer))
 
 	initM := genFreqKeyValues(size, rate)
 	for k, v := range initM {
@@ -372,30 +887,30 @@ func TestMgrMissRate(t *testing.T) {
 	}
 
 	wg.Wait()
-	go printMemStatRepeat(ms)
-	time.Sleep(10 * time.Second)
-	timeCost.DefaultTimeCostAnalyzer.OutputResult()
+	printMemStatRepeat(ms, 10)
+	tracer.OutputResult()
 	fmt.Printf("miss rate %d", mismatchCount)
 }
 
-var value = 0
+// value 被并发的访问goroutine递增，atomic读写
+var value atomic.Int64
 
-func accessKLru(l *lruConcurrent) {
-	l.Add(generateRandomFixedSizeString(3), strconv.Itoa(value))
-	l.Get(generateRandomFixedSizeString(3))
-	value++
+// getAdder lruConcurrent、clru共同的访问方式
+type getAdder interface {
+	Get(key string) (string, bool)
+	Add(key, value string)
 }
 
-func accessKLruWithWG(l ihe_lru.LRU, wg *sync.WaitGroup) {
-	l.Add(generateRandomFixedSizeString(3), strconv.Itoa(value))
+func accessKLruWithWG(l getAdder, wg *sync.WaitGroup) {
+	l.Add(generateRandomFixedSizeString(3), strconv.FormatInt(value.Load(), 10))
 	l.Get(generateRandomFixedSizeString(3))
-	value++
+	value.Add(1)
 	wg.Done()
 }
 
-func accessKLRUWithMissRate(l ihe_lru.LRU, wg *sync.WaitGroup) {
+func accessKLRUWithMissRate(l getAdder, wg *sync.WaitGroup) {
 	if rand.Intn(10)%9 == 1 {
-		l.Add(generateFrequentRandomString(1, rate), strconv.Itoa(value))
+		l.Add(generateFrequentRandomString(1, rate), strconv.FormatInt(value.Load(), 10))
 	}
 	x := generateFrequentRandomString(1, rate)
 	_, ok := l.Get(x)
@@ -403,15 +918,15 @@ func accessKLRUWithMissRate(l ihe_lru.LRU, wg *sync.WaitGroup) {
 		mu.Lock()
 		mismatchCount++
 		mu.Unlock()
-		l.Add(x, strconv.Itoa(value))
+		l.Add(x, strconv.FormatInt(value.Load(), 10))
 	}
-	value++
+	value.Add(1)
 	wg.Done()
 }
 
-func accessKLRUWithMissRateWithoutWg(l ihe_lru.LRU) {
+func accessKLRUWithMissRateWithoutWg(l getAdder) {
 	if rand.Intn(10)%9 == 1 {
-		l.Add(generateFrequentRandomString(1, rate), strconv.Itoa(value))
+		l.Add(generateFrequentRandomString(1, rate), strconv.FormatInt(value.Load(), 10))
 	}
 	x := generateFrequentRandomString(1, rate)
 	_, ok := l.Get(x)
@@ -419,9 +934,9 @@ func accessKLRUWithMissRateWithoutWg(l ihe_lru.LRU) {
 		mu.Lock()
 		mismatchCount++
 		mu.Unlock()
-		l.Add(x, strconv.Itoa(value))
+		l.Add(x, strconv.FormatInt(value.Load(), 10))
 	}
-	value++
+	value.Add(1)
 }
 
 func genKeyValues(size int) map[string]string {
@@ -504,7 +1019,7 @@ func doMeaningless(wg *sync.WaitGroup) {
 //
 //
 //
-//	timeCost.DefaultTimeCostAnalyzer.OutputResult()
+//	tracer.OutputResult()
 //	fmt.Printf("miss rate %d", mismatchCount)
 //
 //}
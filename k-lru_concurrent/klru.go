@@ -0,0 +1,124 @@
+package k_lru_concurrent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidOptions NewKLRU的配置不合法
+var ErrInvalidOptions = errors.New("k-lru: invalid options")
+
+// 未设置时的默认值，与测试中的用法一致
+const (
+	defaultK                  = 2
+	defaultChanSizeRatio      = 3
+	defaultLowThresholdRatio  = 2
+	defaultHighThresholdRatio = 5
+	// defaultCountSize 只限制重量时没有个数可参考，channel与计数阈值按该个数估计
+	defaultCountSize = 1024
+)
+
+// KLRUOptions NewKLRU的配置，零值字段即默认配置
+type KLRUOptions[V any] struct {
+	// Size 元素个数上限，小于等于0表示不限制个数，此时必须设置MaxWeight
+	Size int
+	// K key被访问超过K次才移到栈顶，须大于1，默认2
+	K int
+	// LowThreshold、HighThreshold 记录访问计数的key超过HighThreshold个时，删除最久未访问的HighThreshold-LowThreshold个
+	// 须0 < LowThreshold < HighThreshold，默认Size的2倍、5倍
+	LowThreshold  int
+	HighThreshold int
+	// ChanSize 把访问转交updater的channel容量，默认Size的3倍。channel满时访问被丢弃，不会阻塞Get
+	ChanSize int
+
+	// Options 缓存本身的配置，见NewConcurrentLRUWithOptions
+	Options[V]
+}
+
+// kLRU 并发K-LRU与为它更新访问计数的updater，channel、updater都由它创建，Close时一并停止
+type kLRU[V any] struct {
+	*lruConcurrent[V]
+	updater *recentUseUpdater
+}
+
+// NewKLRU 创建并发K-LRU并启动访问计数更新，不再需要调用方创建channel、updater
+func NewKLRU[V any](opts KLRUOptions[V]) (*kLRU[V], error) {
+	if err := opts.fill(); err != nil {
+		return nil, err
+	}
+	ch := make(chan string, opts.ChanSize)
+	l := NewConcurrentLRUWithOptions(opts.Size, ch, opts.Options)
+	u := NewRecentUseUpdaterWithTracer(opts.K, ch, l.MoveToFront, opts.LowThreshold, opts.HighThreshold, opts.Tracer)
+	u.Run()
+	return &kLRU[V]{lruConcurrent: l, updater: u}, nil
+}
+
+// asyncKLRU 同kLRU，缓存为clru，添加、清理都交给后台goroutine
+type asyncKLRU[V any] struct {
+	*clru[V]
+	updater *recentUseUpdater
+}
+
+// NewAsyncKLRU 同NewKLRU，缓存为clru。clru不支持过期
+func NewAsyncKLRU[V any](opts KLRUOptions[V]) (*asyncKLRU[V], error) {
+	if opts.ExpireAfterWrite > 0 || opts.ExpireAfterAccess > 0 {
+		return nil, fmt.Errorf("%w: async k-lru has no expiry", ErrInvalidOptions)
+	}
+	if err := opts.fill(); err != nil {
+		return nil, err
+	}
+	ch := make(chan string, opts.ChanSize)
+	l := NewCLRUWithOptions(opts.Size, ch, opts.Options)
+	u := NewRecentUseUpdaterWithTracer(opts.K, ch, l.MoveToFront, opts.LowThreshold, opts.HighThreshold, opts.Tracer)
+	u.Run()
+	return &asyncKLRU[V]{clru: l, updater: u}, nil
+}
+
+// Close 同kLRU.Close
+func (k *asyncKLRU[V]) Close() error {
+	err := k.clru.Close()
+	k.updater.Close()
+	return err
+}
+
+// fill 补全默认值并校验
+func (o *KLRUOptions[V]) fill() error {
+	if o.Size <= 0 && o.MaxWeight <= 0 {
+		return fmt.Errorf("%w: size or max weight is required, got size %d", ErrInvalidOptions, o.Size)
+	}
+	n := o.Size
+	if n <= 0 {
+		n = defaultCountSize
+	}
+	if o.K == 0 {
+		o.K = defaultK
+	}
+	if o.LowThreshold == 0 {
+		o.LowThreshold = n * defaultLowThresholdRatio
+	}
+	if o.HighThreshold == 0 {
+		o.HighThreshold = n * defaultHighThresholdRatio
+	}
+	if o.ChanSize == 0 {
+		o.ChanSize = n * defaultChanSizeRatio
+	}
+
+	switch {
+	case o.K <= 1:
+		return fmt.Errorf("%w: k must be greater than 1, got %d", ErrInvalidOptions, o.K)
+	case o.LowThreshold <= 0:
+		return fmt.Errorf("%w: low threshold must be positive, got %d", ErrInvalidOptions, o.LowThreshold)
+	case o.HighThreshold <= o.LowThreshold:
+		return fmt.Errorf("%w: high threshold %d must be greater than low threshold %d", ErrInvalidOptions, o.HighThreshold, o.LowThreshold)
+	case o.ChanSize < 0:
+		return fmt.Errorf("%w: negative chan size %d", ErrInvalidOptions, o.ChanSize)
+	}
+	return nil
+}
+
+// Close 先关闭缓存，不再向channel转交访问，再停止updater，可重复调用
+func (k *kLRU[V]) Close() error {
+	err := k.lruConcurrent.Close()
+	k.updater.Close()
+	return err
+}
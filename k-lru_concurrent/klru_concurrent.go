@@ -1,9 +1,12 @@
 package k_lru_concurrent
 
 import (
+	"container/heap"
 	"container/list"
-	"learn/tool/timeCost"
+	"context"
+	"learn/ihe-lru"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,12 +25,19 @@ import (
 // final: concurrent k-lru
 // Get: return item directly if exists. new another goroutine to put front ele
 // Add: return if exists. add directly if not exceed addLimit, rm if exceed rmLimit
-type item struct {
+type item[V any] struct {
 	key   string
-	value string
+	value V
+	// 过期时间，UnixNano，0表示不过期。expireAt可能被Get并发顺延，需atomic读写
+	writeDeadline int64
+	expireAt      int64
+	// heapDeadline 放入过期堆时的过期时间，index 在过期堆中的位置
+	heapDeadline int64
+	index        int
+	weight       int64
 }
 
-type lruConcurrent struct {
+type lruConcurrent[V any] struct {
 	items          map[string]*list.Element
 	evictList      *list.List
 	size           int
@@ -36,10 +46,47 @@ type lruConcurrent struct {
 	evictThreshold int
 	safeThreshold  int
 	evictCh        chan struct{}
+	onEvict        func(key string, value V, reason ihe_lru.EvictReason)
+
+	expireAfterWrite  time.Duration
+	expireAfterAccess time.Duration
+	now               func() time.Time
+	expiry            expiryHeap[V]
+	expireTicker      *time.Ticker
+
+	// reads 记录Get访问的key，由evict goroutine批量转交ch
+	reads *readBuffer
+
+	// done 关闭后evict退出
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	weigher    func(key string, value V) int64
+	maxWeight  int64
+	safeWeight int64
+	// weight 在写锁下修改，但Add时在锁外读取判断是否需要清理，所以atomic读写
+	weight int64
+
+	// maxSize 元素个数硬上限，达到时按overflow处理
+	maxSize         int
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	// spaceFreed 有Add等待空位时创建，删除元素时关闭以唤醒全部等待者。在写锁下读写
+	spaceFreed chan struct{}
+
+	loads ihe_lru.LoadGroup[string, V]
+	stats ihe_lru.StatsCounter
+	trace trace
+}
+
+func NewConcurrentLRU(size int, ch chan string) *lruConcurrent[string] {
+	return NewConcurrentLRUWithOptions(size, ch, Options[string]{})
 }
 
-func NewConcurrentLRU(size int, ch chan string) *lruConcurrent {
-	l := &lruConcurrent{
+// NewConcurrentLRUWithOptions size为元素个数上限，小于等于0表示不限制个数，仅受opts.MaxWeight限制
+func NewConcurrentLRUWithOptions[V any](size int, ch chan string, opts Options[V]) *lruConcurrent[V] {
+	l := &lruConcurrent[V]{
 		items:          make(map[string]*list.Element),
 		evictList:      list.New(),
 		size:           size,
@@ -48,101 +95,435 @@ func NewConcurrentLRU(size int, ch chan string) *lruConcurrent {
 		ch:             ch,
 		safeThreshold:  size - size/4,
 		evictCh:        make(chan struct{}, 1),
+		onEvict:        opts.OnEvict,
+
+		expireAfterWrite:  opts.ExpireAfterWrite,
+		expireAfterAccess: opts.ExpireAfterAccess,
+		now:               opts.now(),
+		expireTicker:      time.NewTicker(defaultExpireInterval),
+		reads:             newReadBuffer(),
+		done:              make(chan struct{}),
+
+		weigher:    opts.Weigher,
+		maxWeight:  opts.MaxWeight,
+		safeWeight: opts.MaxWeight - opts.MaxWeight/4,
+
+		maxSize:         opts.MaxSize,
+		overflow:        opts.Overflow,
+		overflowTimeout: opts.OverflowTimeout,
+
+		trace: newTrace(opts.Tracer),
 	}
+	l.wg.Add(1)
 	go l.evict()
 	return l
 }
 
-func (l *lruConcurrent) Get(key string) (string, bool) {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.GetItem)()
+func (l *lruConcurrent[V]) Get(key string) (V, bool) {
+	defer l.trace.record(GetItem, l.trace.start())
 	// 1. 查看是否在缓存中存在
+	// Add更新时在写锁下替换Value，需在读锁内取出item。item本身除expireAt外创建后不再修改
 	l.mu.RLock()
-	i, ok := l.items[key]
+	var it *item[V]
+	e, ok := l.items[key]
+	if ok {
+		it = e.Value.(*item[V])
+	}
 	l.mu.RUnlock()
 	if !ok {
-		return "", false
+		l.stats.RecordMiss()
+		var zero V
+		return zero, false
 	}
 
+	// 过期元素视为不存在，等待后台清理
+	if exp := atomic.LoadInt64(&it.expireAt); exp != 0 {
+		now := l.now().UnixNano()
+		if exp <= now {
+			l.stats.RecordMiss()
+			var zero V
+			return zero, false
+		}
+		if l.expireAfterAccess > 0 {
+			atomic.StoreInt64(&it.expireAt, minDeadline(it.writeDeadline, now+int64(l.expireAfterAccess)))
+		}
+	}
+	l.stats.RecordHit()
+
 	// 2. 通知将该元素访问次数增加
 	// 问题在于被删了之后的元素难道真的应该保留原来的计数嘛
 	defer l.notifyPushFront(key)
 
 	// 3. 返回查出来的元素
 	// 查出来后被删了，其实也不是什么大问题
-	return i.Value.(*item).value, true
+	return it.value, true
 }
 
-func (l *lruConcurrent) Add(key, value string) {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.AddItem)()
-	i := &item{
-		key:   key,
-		value: value,
+// GetOrLoad 命中直接返回，未命中时调用loader加载并添加。同一key并发未命中只会执行一次loader，错误不会被缓存
+func (l *lruConcurrent[V]) GetOrLoad(ctx context.Context, key string, loader ihe_lru.Loader[string, V]) (V, error) {
+	if v, ok := l.Get(key); ok {
+		return v, nil
+	}
+	return l.loads.Do(ctx, key, func(ctx context.Context) (V, error) {
+		v, err := loader(ctx, key)
+		l.stats.RecordLoad(err)
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+		l.Add(key, v)
+		return v, nil
+	})
+}
+
+func (l *lruConcurrent[V]) Add(key string, value V) {
+	l.AddWithTTL(key, value, l.expireAfterWrite)
+}
+
+// AddWithTTL 同Add，且元素在ttl后过期，ttl为0表示不过期
+func (l *lruConcurrent[V]) AddWithTTL(key string, value V, ttl time.Duration) {
+	l.add(key, value, ttl)
+}
+
+// TryAdd 同Add，返回ErrFull表示达到MaxSize而未添加，见Overflow
+func (l *lruConcurrent[V]) TryAdd(key string, value V) error {
+	return l.add(key, value, l.expireAfterWrite)
+}
+
+func (l *lruConcurrent[V]) add(key string, value V, ttl time.Duration) error {
+	defer l.trace.record(AddItem, l.trace.start())
+	i := &item[V]{
+		key:    key,
+		value:  value,
+		index:  -1,
+		weight: l.weigh(key, value),
+	}
+	// 单个元素就超过重量限制，不可能放入缓存，视为立即被驱逐。已存在的旧值也不应继续提供
+	if l.maxWeight > 0 && i.weight > l.maxWeight {
+		l.mu.Lock()
+		e, ok := l.items[key]
+		var old *item[V]
+		if ok {
+			old = l.removeElement(e)
+		}
+		l.mu.Unlock()
+		if ok {
+			l.notifyEvict(old, ihe_lru.EvictByReplace)
+		}
+		l.notifyEvict(i, ihe_lru.EvictByCapacity)
+		return nil
+	}
+	if ttl > 0 || l.expireAfterAccess > 0 {
+		now := l.now().UnixNano()
+		if ttl > 0 {
+			i.writeDeadline = now + int64(ttl)
+		}
+		i.expireAt = i.writeDeadline
+		if l.expireAfterAccess > 0 {
+			i.expireAt = minDeadline(i.writeDeadline, now+int64(l.expireAfterAccess))
+		}
 	}
 	// 1. 判断是否存在该key，若存在更新，并将其访问次数加1
 	// 到顶还是到底呢？若是底，刚加就删，似乎不是很好。若是顶，是不是会导致最近添加的挤压掉大量实际多次被访问的呢？那就底吧！
 	// 这是最近最少访问，在没有最少的情况下，当然以近为先
-	// 并不认为将访问items独立锁出去会更好，因为可能查出来被删了，那么即使如此，更新仍然没问题吧（虽然也有被gc回收的风险）
-	// 重点在于好处呢？如果假定不会有这么多更新的话，其实该锁的还是要锁
-	rn := time.Now()
-	l.mu.RLock()
-	e, ok := l.items[key]
-	l.mu.RUnlock()
-	if ok {
-		e.Value = i
-		return
+	// 更新需要同时维护过期堆，新增需要修改evictList，都只能加写锁。只加一次写锁并在锁内判断，并发Add同一个新key也不会重复添加
+	rn := l.trace.start()
+	start := l.trace.start()
+	l.mu.Lock()
+	l.trace.record(AcquireAddItemLock, start)
+	var evicted []*item[V]
+	var deadline time.Time
+	for {
+		if e, ok := l.items[key]; ok {
+			old := e.Value.(*item[V])
+			e.Value = i
+			l.removeExpiry(old)
+			l.pushExpiry(i)
+			atomic.AddInt64(&l.weight, i.weight-old.weight)
+			needEvict := l.overThreshold()
+			l.mu.Unlock()
+			l.notifyEvict(old, ihe_lru.EvictByReplace)
+			if needEvict {
+				l.notifyEvictUnused()
+			}
+			return nil
+		}
+		if !l.atMaxSize() {
+			break
+		}
+		// 后台清理没跟上，已达到硬上限
+		if l.overflow == OverflowEvict {
+			evicted = append(evicted, l.removeElement(l.evictList.Back()))
+			continue
+		}
+		// 等待期间释放了写锁，key可能已被添加，重新判断
+		if l.overflow == OverflowBlock && l.waitForSpace(&deadline) {
+			continue
+		}
+		l.mu.Unlock()
+		l.stats.RecordRejection()
+		l.notifyEvictUnused()
+		return ErrFull
 	}
 	// 2. 若元素不存在该key，则添加该元素至栈底，并将其访问次数加1
-	now := time.Now()
-	l.mu.Lock()
-	timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.AcquireAddItemLock, now)
-	e = l.evictList.PushBack(i)
+	e := l.evictList.PushBack(i)
 	l.items[key] = e
+	l.pushExpiry(i)
+	atomic.AddInt64(&l.weight, i.weight)
+	// 阈值可能被Resize修改，在锁内判断
+	needEvict := l.overThreshold()
 	l.mu.Unlock()
-	timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.RealAddItem, rn)
+	l.trace.record(RealAddItem, rn)
 
+	for _, old := range evicted {
+		l.notifyEvict(old, ihe_lru.EvictByCapacity)
+	}
 	// 既然假定Add发生次数并不多，那么为什么不阻塞呢？那这样甚至根本不需要添加阈值
 	// 为什么需要删除阈值呢？就是在确保add足够的快。
 	// 或许坚定add、get足够快，而对于添加到evict栈顶、删除等后台操作应该滞后
 	// 3. 栈大于阈值，清理
-	if l.evictList.Len() > l.evictThreshold && len(l.evictCh) == 0 {
-		now = time.Now()
-		l.evictCh <- struct{}{}
-		timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.EvictUnusedItem, now)
+	if needEvict {
+		l.notifyEvictUnused()
+	}
+	return nil
+}
+
+// atMaxSize 已达到个数硬上限，新增前需腾出空位。需持有锁
+func (l *lruConcurrent[V]) atMaxSize() bool {
+	return l.maxSize > 0 && l.evictList.Len() >= l.maxSize
+}
+
+// waitForSpace 释放写锁，通知后台清理并等待有元素被删除，返回前重新加写锁。超时或已Close返回false
+func (l *lruConcurrent[V]) waitForSpace(deadline *time.Time) bool {
+	if l.overflowTimeout > 0 && deadline.IsZero() {
+		*deadline = time.Now().Add(l.overflowTimeout)
+	}
+	if l.spaceFreed == nil {
+		l.spaceFreed = make(chan struct{})
+	}
+	freed := l.spaceFreed
+	l.mu.Unlock()
+	l.notifyEvictUnused()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(*deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ok := true
+	select {
+	case <-freed:
+	case <-timeout:
+		ok = false
+	case <-l.done:
+		ok = false
+	}
+	l.mu.Lock()
+	return ok
+}
+
+// Set 同Add，供ihe_lru.Cache使用
+func (l *lruConcurrent[V]) Set(key string, value V) {
+	l.Add(key, value)
+}
+
+// Remove 删除key对应元素，返回是否存在
+func (l *lruConcurrent[V]) Remove(key string) bool {
+	l.mu.Lock()
+	e, ok := l.items[key]
+	var i *item[V]
+	if ok {
+		i = l.removeElement(e)
 	}
+	l.mu.Unlock()
+	if ok {
+		l.notifyEvict(i, ihe_lru.EvictByRemove)
+	}
+	return ok
+}
+
+// Len 返回元素个数，超出上限的部分等待后台清理，可能短暂大于size
+func (l *lruConcurrent[V]) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.evictList.Len()
+}
+
+// Close 停止过期清理并等待后台清理goroutine退出，可重复调用。之后Get记录的访问不再转交，updater需另行Close
+func (l *lruConcurrent[V]) Close() error {
+	l.closeOnce.Do(func() {
+		l.expireTicker.Stop()
+		close(l.done)
+		l.wg.Wait()
+	})
+	return nil
+}
+
+// Resize 调整元素个数上限并重新计算清理阈值。缩小时立即从栈底驱逐到新的上限之内，其余交由后台清理
+func (l *lruConcurrent[V]) Resize(size int) {
+	var evicted []*item[V]
+	l.mu.Lock()
+	l.size = size
+	l.evictThreshold = size
+	l.safeThreshold = size - size/4
+	for l.size > 0 && l.evictList.Len() > l.evictThreshold {
+		evicted = append(evicted, l.removeElement(l.evictList.Back()))
+	}
+	l.mu.Unlock()
+
+	for _, i := range evicted {
+		l.notifyEvict(i, ihe_lru.EvictByCapacity)
+	}
+}
+
+// notifyEvictUnused evictCh中已有通知时不必重复，Close后evict不再接收，也不能阻塞
+func (l *lruConcurrent[V]) notifyEvictUnused() {
+	start := l.trace.start()
+	select {
+	case l.evictCh <- struct{}{}:
+	default:
+	}
+	l.trace.record(EvictUnusedItem, start)
+}
+
+// overThreshold 个数或重量超过限制，需要清理。需持有锁
+func (l *lruConcurrent[V]) overThreshold() bool {
+	return (l.size > 0 && l.evictList.Len() > l.evictThreshold) ||
+		(l.maxWeight > 0 && atomic.LoadInt64(&l.weight) > l.maxWeight)
+}
+
+// overSafeThreshold 个数或重量仍未回到安全线之下，或仍在硬上限，需继续清理。需持有锁
+func (l *lruConcurrent[V]) overSafeThreshold() bool {
+	return (l.size > 0 && l.evictList.Len() > l.safeThreshold) ||
+		(l.maxWeight > 0 && atomic.LoadInt64(&l.weight) > l.safeWeight) ||
+		l.atMaxSize()
+}
+
+func (l *lruConcurrent[V]) weigh(key string, value V) int64 {
+	if l.weigher == nil {
+		return 1
+	}
+	return l.weigher(key, value)
 }
 
 // 我并不认为为items、evictList分别设置锁，是多么明智的选择，基本上对items的修改都涉及到对evictList的修改
 // add 太快时有来不及evict风险
-func (l *lruConcurrent) evict() {
+func (l *lruConcurrent[V]) evict() {
+	defer l.wg.Done()
 	for {
 		select {
+		case <-l.done:
+			return
+		case <-l.expireTicker.C:
+			l.removeExpired()
+			// 访问不多时条带到不了阈值，定期排空
+			l.drainReads()
+		case <-l.reads.drainCh:
+			l.drainReads()
 		case <-l.evictCh:
-			now := time.Now()
+			start := l.trace.start()
 			l.mu.Lock()
-			timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.AcquireEvictUnusedItemLock, now)
+			l.trace.record(AcquireEvictUnusedItemLock, start)
 
-			for l.evictList.Len() > l.safeThreshold {
-				// 若为空，那么不就会panic嘛。这怎么会为空呢？
-				back := l.evictList.Back()
-				l.evictList.Remove(back)
-				delete(l.items, back.Value.(*item).key)
+			var evicted []*item[V]
+			for l.evictList.Len() > 0 && l.overSafeThreshold() {
+				evicted = append(evicted, l.removeElement(l.evictList.Back()))
 			}
 			l.mu.Unlock()
-			timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.EvictUnusedItem, now)
+			l.trace.record(EvictUnusedItem, start)
+
+			// 回调可能很慢，放到锁外。统计也随之记录
+			for _, i := range evicted {
+				l.notifyEvict(i, ihe_lru.EvictByCapacity)
+			}
 		}
 	}
 }
 
+// removeExpired 从过期堆顶开始清理已过期的元素
+func (l *lruConcurrent[V]) removeExpired() {
+	now := l.now().UnixNano()
+	var expired []*item[V]
+	l.mu.Lock()
+	for len(l.expiry) > 0 && l.expiry[0].heapDeadline <= now {
+		i := l.expiry[0]
+		// 被Get顺延了，调整在堆中的位置
+		if exp := atomic.LoadInt64(&i.expireAt); exp > now {
+			i.heapDeadline = exp
+			heap.Fix(&l.expiry, 0)
+			continue
+		}
+		heap.Pop(&l.expiry)
+		l.removeElement(l.items[i.key])
+		expired = append(expired, i)
+	}
+	l.mu.Unlock()
+
+	for _, i := range expired {
+		l.notifyEvict(i, ihe_lru.EvictByExpire)
+	}
+}
+
+// removeElement 从evictList、items、过期堆中删除元素，需持有写锁
+func (l *lruConcurrent[V]) removeElement(e *list.Element) *item[V] {
+	l.evictList.Remove(e)
+	i := e.Value.(*item[V])
+	delete(l.items, i.key)
+	l.removeExpiry(i)
+	atomic.AddInt64(&l.weight, -i.weight)
+	if l.spaceFreed != nil {
+		close(l.spaceFreed)
+		l.spaceFreed = nil
+	}
+	return i
+}
+
+// pushExpiry、removeExpiry 需持有写锁
+func (l *lruConcurrent[V]) pushExpiry(i *item[V]) {
+	if i.expireAt != 0 {
+		i.heapDeadline = i.expireAt
+		heap.Push(&l.expiry, i)
+	}
+}
+
+func (l *lruConcurrent[V]) removeExpiry(i *item[V]) {
+	if i.index >= 0 {
+		heap.Remove(&l.expiry, i.index)
+	}
+}
+
+// Stats 返回命中、驱逐等统计。被驱逐的元素在通知回调前计入
+func (l *lruConcurrent[V]) Stats() ihe_lru.Stats {
+	l.mu.RLock()
+	size := l.evictList.Len()
+	l.mu.RUnlock()
+	return l.stats.Snapshot(size, atomic.LoadInt64(&l.weight))
+}
+
+func (l *lruConcurrent[V]) notifyEvict(i *item[V], reason ihe_lru.EvictReason) {
+	l.stats.RecordEviction(reason)
+	if l.onEvict != nil {
+		l.onEvict(i.key, i.value, reason)
+	}
+}
+
 // 当访问次数过多时时，通知更新channel便成为巨大的瓶颈，一时间可能有1000倍于chan的访问量，那么update access count 根本来不及处理
 // 1. 批量，让其批量更新
 // 2. 与其批量更新不如，提升处理的速度
-func (l *lruConcurrent) notifyPushFront(key string) {
-	//now := time.Now()
-	l.ch <- key
-	//timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.NotifyPushFront, now)
+// 3. 记录到有损的分条带读缓冲，由evict goroutine批量转交，Get不再阻塞
+func (l *lruConcurrent[V]) notifyPushFront(key string) {
+	l.reads.Record(key)
+}
+
+// drainReads 排空读缓冲，转交updater更新访问计数
+func (l *lruConcurrent[V]) drainReads() {
+	start := l.trace.start()
+	l.reads.Drain(l.reads.forwardTo(l.ch))
+	l.trace.record(NotifyPushFront, start)
 }
 
-func (l *lruConcurrent) MoveToFront(key string) {
+func (l *lruConcurrent[V]) MoveToFront(key string) {
 	// 放到栈顶的元素可能会被删掉啦
 	// concurrent map read and map write
 	// 如果先readLock判断是否存在，之后lock移到顶，那么可能会使得一个item被多次移到顶
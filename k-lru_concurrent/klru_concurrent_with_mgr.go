@@ -1,18 +1,33 @@
 package k_lru_concurrent
 
 import (
-	"learn/tool/timeCost"
+	"context"
+	"learn/ihe-lru"
 )
 
-type clru struct {
-	mgr  *lruMgr
-	size int
-	ch   chan string
+type clru[V any] struct {
+	mgr   *lruMgr[V]
+	size  int
+	ch    chan string
+	loads ihe_lru.LoadGroup[string, V]
 }
 
-func NewCLRU(size int, ch chan string) *clru {
-	m := NewLRUMgr(size, size-size/4, size*10)
-	l := &clru{
+func NewCLRU(size int, ch chan string) *clru[string] {
+	return NewCLRUWithOptions(size, ch, Options[string]{})
+}
+
+// opsSizeRatio ops的容量为元素个数上限的倍数
+const opsSizeRatio = 10
+
+// NewCLRUWithOptions size为元素个数上限，小于等于0表示不限制个数，仅受opts.MaxWeight限制
+func NewCLRUWithOptions[V any](size int, ch chan string, opts Options[V]) *clru[V] {
+	// 只限制重量时ops的容量同样按defaultCountSize估计，无缓冲的ops会让每次Add都等待handleOp
+	n := size
+	if n <= 0 {
+		n = defaultCountSize
+	}
+	m := newLRUMgr(size, size-size/4, n*opsSizeRatio, opts, ch)
+	l := &clru[V]{
 		mgr:  m,
 		size: size,
 		ch:   ch,
@@ -21,13 +36,16 @@ func NewCLRU(size int, ch chan string) *clru {
 	return l
 }
 
-func (l *clru) Get(key string) (string, bool) {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.GetItem)()
+func (l *clru[V]) Get(key string) (V, bool) {
+	defer l.mgr.trace.record(GetItem, l.mgr.trace.start())
 	// 1. 查看是否在缓存中存在
 	i, ok := l.mgr.Get(key)
 	if !ok {
-		return "", false
+		l.mgr.stats.RecordMiss()
+		var zero V
+		return zero, false
 	}
+	l.mgr.stats.RecordHit()
 
 	// 2. 通知将该元素访问次数增加
 	l.notifyPushFront(key)
@@ -36,30 +54,100 @@ func (l *clru) Get(key string) (string, bool) {
 	return i.value, true
 }
 
-func (l *clru) Add(key, value string) {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.AddItem)()
+// GetOrLoad 命中直接返回，未命中时调用loader加载并添加。同一key并发未命中只会执行一次loader，错误不会被缓存
+func (l *clru[V]) GetOrLoad(ctx context.Context, key string, loader ihe_lru.Loader[string, V]) (V, error) {
+	if v, ok := l.Get(key); ok {
+		return v, nil
+	}
+	return l.loads.Do(ctx, key, func(ctx context.Context) (V, error) {
+		v, err := loader(ctx, key)
+		l.mgr.stats.RecordLoad(err)
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+		l.Add(key, v)
+		return v, nil
+	})
+}
 
-	i := &item{
-		key:   key,
-		value: value,
+func (l *clru[V]) Add(key string, value V) {
+	l.TryAdd(key, value)
+}
+
+// TryAdd 同Add，返回ErrFull表示达到MaxSize而未添加，见Overflow
+func (l *clru[V]) TryAdd(key string, value V) error {
+	defer l.mgr.trace.record(AddItem, l.mgr.trace.start())
+
+	i := &item[V]{
+		key:    key,
+		value:  value,
+		weight: l.mgr.weigh(key, value),
+	}
+	// 单个元素就超过重量限制，不可能放入缓存，视为立即被驱逐。已存在的旧值也不应继续提供
+	if l.mgr.maxWeight > 0 && i.weight > l.mgr.maxWeight {
+		l.mgr.Remove(key, ihe_lru.EvictByReplace)
+		l.mgr.notifyEvictCallback(i, ihe_lru.EvictByCapacity)
+		return nil
 	}
+
 	// 1. 判断是否存在该key，若存在更新，并将其访问次数加1
 	ok := l.mgr.TryUpdate(key, i)
 	if ok {
-		return
+		return nil
 	}
 
-	// 2. 若元素不存在该key，则添加该元素至栈底，并将其访问次数加1
+	// 2. 若元素不存在该key，预留位置后添加，Get立即可见，由后台放入栈底
+	if err := l.mgr.reserve(); err != nil {
+		return err
+	}
 	l.mgr.NotifyAdd(key, i)
 
 	// 3. 清理
 	l.mgr.NotifyEvict()
+	return nil
+}
+
+// Set 同Add，供ihe_lru.Cache使用
+func (l *clru[V]) Set(key string, value V) {
+	l.Add(key, value)
+}
+
+// Remove 删除key对应元素，返回是否存在。删除后立即不可见，evictList由后台移除
+func (l *clru[V]) Remove(key string) bool {
+	return l.mgr.Remove(key, ihe_lru.EvictByRemove)
+}
+
+// Len 返回元素个数。刚Add的元素已计入，超出阈值的元素由后台清理，个数可能暂时超过size
+func (l *clru[V]) Len() int {
+	return l.mgr.Len()
+}
+
+// Close 等待后台处理完已入队的添加、清理并退出，可重复调用。updater需另行Close
+func (l *clru[V]) Close() error {
+	return l.mgr.Close()
+}
+
+// Resize 调整元素个数上限，缩小时由后台从栈底清理
+func (l *clru[V]) Resize(size int) {
+	l.mgr.Resize(size, size-size/4)
+}
+
+// Stats 返回命中、驱逐等统计。清理是异步的，元素个数可能暂时超过size，刚Add的元素尚未计入Weight
+func (l *clru[V]) Stats() ihe_lru.Stats {
+	return l.mgr.Stats()
+}
+
+// OpStats 返回后台处理添加、清理、移到栈顶等操作的统计
+func (l *clru[V]) OpStats() OpStats {
+	return l.mgr.OpStats()
 }
 
-func (l *clru) notifyPushFront(key string) {
-	l.ch <- key
+// notifyPushFront 记录到有损的读缓冲，由mgr的handleOp批量转交ch，Get不会阻塞
+func (l *clru[V]) notifyPushFront(key string) {
+	l.mgr.RecordAccess(key)
 }
 
-func (l *clru) MoveToFront(key string) {
+func (l *clru[V]) MoveToFront(key string) {
 	l.mgr.NotifyMoveToFront(key)
 }
@@ -0,0 +1,106 @@
+package k_lru_concurrent
+
+import (
+	"errors"
+	"learn/ihe-lru"
+	"learn/ihe-lru/cachetest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	_ ihe_lru.Cache[string, string] = (*kLRU[string])(nil)
+	_ ihe_lru.Cache[string, string] = (*asyncKLRU[string])(nil)
+)
+
+func TestNewKLRU(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	size := 10
+	l, err := NewKLRU(KLRUOptions[string]{Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.updater.k != defaultK || l.updater.cleanHighThreshold != size*defaultHighThresholdRatio || cap(l.ch) != size*defaultChanSizeRatio {
+		t.Fatalf("bad defaults k %d high %d chan %d", l.updater.k, l.updater.cleanHighThreshold, cap(l.ch))
+	}
+
+	for i := 0; i < size*3; i++ {
+		key := strconv.Itoa(i)
+		l.Add(key, key)
+		if v, ok := l.Get(key); !ok || v != key {
+			t.Fatalf("want %s, got %s %v", key, v, ok)
+		}
+	}
+	waitUntilLen(l, size)
+	if n := l.Len(); n > size {
+		t.Fatalf("want len <= %d, got %d", size, n)
+	}
+}
+
+func TestNewKLRUInvalid(t *testing.T) {
+	cases := map[string]KLRUOptions[string]{
+		"no size":      {},
+		"k":            {Size: 10, K: 1},
+		"negative k":   {Size: 10, K: -1},
+		"low":          {Size: 10, LowThreshold: -1},
+		"high <= low":  {Size: 10, LowThreshold: 50, HighThreshold: 50},
+		"default high": {Size: 10, LowThreshold: 100},
+		"chan size":    {Size: 10, ChanSize: -1},
+	}
+	for name, opts := range cases {
+		if _, err := NewKLRU(opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: want ErrInvalidOptions, got %v", name, err)
+		}
+	}
+}
+
+func TestNewAsyncKLRU(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	size := 10
+	l, err := NewAsyncKLRU(KLRUOptions[string]{Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.updater.k != defaultK || cap(l.ch) != size*defaultChanSizeRatio {
+		t.Fatalf("bad defaults k %d chan %d", l.updater.k, cap(l.ch))
+	}
+
+	for i := 0; i < size*3; i++ {
+		key := strconv.Itoa(i)
+		l.Add(key, key)
+	}
+	waitUntilExists(l, strconv.Itoa(size*3-1))
+	waitUntilLen(l, size)
+	if n := l.Len(); n > size {
+		t.Fatalf("want len <= %d, got %d", size, n)
+	}
+
+	if _, err := NewAsyncKLRU(KLRUOptions[string]{Size: size, Options: Options[string]{ExpireAfterWrite: time.Second}}); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("want ErrInvalidOptions for expiry, got %v", err)
+	}
+}
+
+// TestNewAsyncKLRUWeightOnly 只限制重量时ops同样有缓冲，Add不必等待后台处理
+func TestNewAsyncKLRUWeightOnly(t *testing.T) {
+	l := newTestAsyncKLRU(t, KLRUOptions[string]{Options: Options[string]{MaxWeight: 100}})
+	if s := l.OpStats(); s.QueueCap != defaultCountSize*opsSizeRatio {
+		t.Fatalf("want ops cap %d, got %d", defaultCountSize*opsSizeRatio, s.QueueCap)
+	}
+	for i := 0; i < 300; i++ {
+		key := strconv.Itoa(i)
+		l.Add(key, key)
+	}
+	waitUntilLen(l, 100)
+	if n := l.Len(); n > 100 {
+		t.Fatalf("want len <= 100, got %d", n)
+	}
+}
+
+func waitUntilLen(l interface{ Len() int }, n int) {
+	for i := 0; i < 100 && l.Len() > n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
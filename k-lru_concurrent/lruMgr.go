@@ -2,7 +2,7 @@ package k_lru_concurrent
 
 import (
 	"container/list"
-	"learn/tool/timeCost"
+	"learn/ihe-lru"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,6 +14,7 @@ const (
 	add opType = iota
 	moveToFront
 	evict
+	remove
 )
 
 type state int
@@ -24,145 +25,705 @@ const (
 	idle
 )
 
-type evictState atomic.Value
+// evictState 调用方goroutine判断并切换为running，handleOp清理完切回idle
+type evictState struct {
+	v atomic.Int32
+}
 
 func (s *evictState) IsIdle() bool {
-	return (*atomic.Value)(s).Load() == idle
+	return state(s.v.Load()) == idle
 }
 
 func (s *evictState) SetState(x state) {
-	(*atomic.Value)(s).Store(x)
+	s.v.Store(int32(x))
+}
+
+// TryRun 由idle切换为running，返回是否切换成功。多个调用方同时判断时只有一个成功，不会重复通知清理
+func (s *evictState) TryRun() bool {
+	return s.v.CompareAndSwap(int32(idle), int32(running))
 }
 
+// maxOpBatch handleOp一次最多取出的操作个数，也是清理时一次持锁最多移除的元素个数，避免长时间阻塞Get
+const maxOpBatch = 256
+
 type lruOp struct {
 	eop opType
 	e   *list.Element
-	v   interface{}
 	key string
 }
 
-type lruMgr struct {
-	ops           chan *lruOp
-	threshold     int
-	items         map[string]*list.Element
-	evictList     *list.List
-	mu            sync.RWMutex
+type lruMgr[V any] struct {
+	ops chan *lruOp
+	// threshold、safeThreshold 可能被Resize修改，atomic读写
+	threshold int64
+	// items 及其中元素的Value在mu下读写；evictList只在handleOp中访问，但list.Remove会读取Value，需加锁
+	items map[string]*list.Element
+	// pending 已入队、handleOp尚未加入items的新元素，在mu下读写，同一个key只会在两者之一中。
+	// 添加后Get立即可见、Remove立即生效，handleOp处理add时取出当前的元素，已被删除时跳过
+	pending   map[string]*item[V]
+	evictList *list.List
+	mu        sync.RWMutex
+	// length items的元素个数，在mu下修改，NotifyEvict在调用方goroutine读取，所以atomic读写
+	length        int64
 	es            evictState
-	safeThreshold int
+	safeThreshold int64
+	onEvict       func(key string, value V, reason ihe_lru.EvictReason)
+
+	weigher    func(key string, value V) int64
+	maxWeight  int64
+	safeWeight int64
+	// weight 只在handleOp中修改，但NotifyEvict在调用方goroutine读取，所以atomic读写
+	weight int64
+
+	// maxSize 元素个数硬上限，达到时按overflow处理
+	maxSize         int64
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	// reserved 已在items与pending中的元素个数，仅在设置maxSize时维护，在mu下减少，atomic读写
+	// 添加前先预留，items与pending的元素个数之和不会超过reserved
+	reserved int64
+	// spaceFreed 有Add等待空位时创建，释放预留时关闭以唤醒全部等待者。在mu下读写
+	spaceFreed chan struct{}
+
+	stats ihe_lru.StatsCounter
+	trace trace
+
+	// reads 记录clru Get访问的key，由handleOp批量转交readCh。直接使用lruMgr时为nil
+	reads       *readBuffer
+	readCh      chan string
+	drainTicker *time.Ticker
+
+	// batch、seen、evicted handleOp复用的缓冲
+	batch   []*lruOp
+	seen    map[*list.Element]struct{}
+	evicted []*item[V]
+	opStats opCounters
+
+	// done 关闭后handleOp处理完已入队的操作后退出，之后的操作直接丢弃
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	// sendMu 发送方检查done到写入ops之间持读锁，Close持写锁统计handleOp退出后仍留在ops中的操作
+	sendMu sync.RWMutex
 }
 
-func NewLRUMgr(threshold, safeThreshold, optsSize int) *lruMgr {
-	es := evictState{}
-	es.SetState(idle)
-	m := &lruMgr{
+func NewLRUMgr(threshold, safeThreshold, optsSize int) *lruMgr[string] {
+	return NewLRUMgrWithOptions(threshold, safeThreshold, optsSize, Options[string]{})
+}
+
+// NewLRUMgrWithOptions threshold小于等于0表示不限制个数，仅受opts.MaxWeight限制
+func NewLRUMgrWithOptions[V any](threshold, safeThreshold, optsSize int, opts Options[V]) *lruMgr[V] {
+	return newLRUMgr(threshold, safeThreshold, optsSize, opts, nil)
+}
+
+// newLRUMgr readCh不为nil时，记录的访问由handleOp转交readCh
+func newLRUMgr[V any](threshold, safeThreshold, optsSize int, opts Options[V], readCh chan string) *lruMgr[V] {
+	m := &lruMgr[V]{
 		ops:           make(chan *lruOp, optsSize),
-		threshold:     threshold,
+		threshold:     int64(threshold),
 		items:         make(map[string]*list.Element),
+		pending:       make(map[string]*item[V]),
 		evictList:     list.New(),
-		mu:            sync.RWMutex{},
-		es:            es,
-		safeThreshold: safeThreshold,
+		safeThreshold: int64(safeThreshold),
+		onEvict:       opts.OnEvict,
+
+		weigher:    opts.Weigher,
+		maxWeight:  opts.MaxWeight,
+		safeWeight: opts.MaxWeight - opts.MaxWeight/4,
+
+		maxSize:         int64(opts.MaxSize),
+		overflow:        opts.Overflow,
+		overflowTimeout: opts.OverflowTimeout,
+
+		batch: make([]*lruOp, 0, maxOpBatch),
+		seen:  make(map[*list.Element]struct{}),
+		trace: newTrace(opts.Tracer),
+		done:  make(chan struct{}),
+	}
+	m.es.SetState(idle)
+	if readCh != nil {
+		m.reads = newReadBuffer()
+		m.readCh = readCh
+		m.drainTicker = time.NewTicker(defaultExpireInterval)
 	}
 
+	m.wg.Add(1)
 	go m.handleOp()
 	return m
 }
 
-func (m *lruMgr) Get(key string) (*item, bool) {
+func (m *lruMgr[V]) Get(key string) (*item[V], bool) {
 	m.mu.RLock()
-	i, ok := m.items[key]
-	m.mu.RUnlock()
+	defer m.mu.RUnlock()
+	e, ok := m.items[key]
 	if !ok {
-		return nil, false
+		i, ok := m.pending[key]
+		return i, ok
 	}
-
-	return i.Value.(*item), true
+	// TryUpdate会并发替换Value，需在锁内读取。item本身创建后不再修改
+	return e.Value.(*item[V]), true
 }
 
-func (m *lruMgr) TryUpdate(key string, i *item) bool {
-	m.mu.RLock()
-	e, ok := m.items[key]
-	m.mu.RUnlock()
+// TryUpdate key存在时在写锁下替换为i，返回是否存在
+func (m *lruMgr[V]) TryUpdate(key string, i *item[V]) bool {
+	m.mu.Lock()
+	old, ok := m.replaceLocked(key, i)
+	m.mu.Unlock()
 	if ok {
-		e.Value = i
-		return true
+		m.notifyEvictCallback(old, ihe_lru.EvictByReplace)
 	}
+	return ok
+}
 
-	return false
+// replaceLocked key在items或pending中时替换为i，返回旧元素。需持有写锁
+// pending中的元素尚未计入weight，handleOp加入items时才计入
+func (m *lruMgr[V]) replaceLocked(key string, i *item[V]) (*item[V], bool) {
+	if e, ok := m.items[key]; ok {
+		old := e.Value.(*item[V])
+		e.Value = i
+		atomic.AddInt64(&m.weight, i.weight-old.weight)
+		return old, true
+	}
+	old, ok := m.pending[key]
+	if ok {
+		m.pending[key] = i
+	}
+	return old, ok
 }
 
-func (m *lruMgr) NotifyAdd(key string, i *item) {
+// NotifyAdd 添加已预留位置的新元素，放入pending后立即可见，再入队由handleOp加入evictList
+func (m *lruMgr[V]) NotifyAdd(key string, i *item[V]) {
 	m.notifyAdd(key, i)
 }
 
-func (m *lruMgr) notifyAdd(key string, i *item) {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.AddItem)()
-	op := &lruOp{
-		eop: add,
-		key: key,
-		v:   i,
+func (m *lruMgr[V]) notifyAdd(key string, i *item[V]) {
+	defer m.trace.record(AddItem, m.trace.start())
+	if !m.addPending(key, i) {
+		return
+	}
+	if !m.send(&lruOp{eop: add, key: key}) {
+		m.dropAdd(key)
+	}
+}
+
+// addPending 放入pending，返回是否需要入队。并发Add同一个新key时两者的TryUpdate都会失败，
+// 后放入的替换先放入的，并释放自己预留的位置
+func (m *lruMgr[V]) addPending(key string, i *item[V]) bool {
+	m.mu.Lock()
+	old, ok := m.replaceLocked(key, i)
+	if ok {
+		m.releaseLocked()
+	} else {
+		m.pending[key] = i
+	}
+	m.mu.Unlock()
+	if ok {
+		m.notifyEvictCallback(old, ihe_lru.EvictByReplace)
+	}
+	return !ok
+}
+
+// dropAdd 未被处理的add丢弃时，从pending删除并释放预留的位置
+func (m *lruMgr[V]) dropAdd(key string) {
+	m.mu.Lock()
+	if _, ok := m.pending[key]; ok {
+		delete(m.pending, key)
+		m.releaseLocked()
 	}
-	m.ops <- op
+	m.mu.Unlock()
 }
 
-func (m *lruMgr) NotifyEvict() {
-	if m.evictList.Len() > m.threshold && m.es.IsIdle() {
-		m.es.SetState(running)
+// Remove 从items或pending中删除key对应元素并回调，reason为回调时的原因，返回是否存在。evictList由handleOp移除
+func (m *lruMgr[V]) Remove(key string, reason ihe_lru.EvictReason) bool {
+	m.mu.Lock()
+	e, ok := m.items[key]
+	var i *item[V]
+	if ok {
+		i = e.Value.(*item[V])
+		delete(m.items, key)
+		atomic.AddInt64(&m.length, -1)
+		atomic.AddInt64(&m.weight, -i.weight)
+		m.releaseLocked()
+	} else if i, ok = m.pending[key]; ok {
+		// 已入队的add处理时跳过
+		delete(m.pending, key)
+		m.releaseLocked()
+	}
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	m.notifyEvictCallback(i, reason)
+	if e != nil {
+		m.send(&lruOp{
+			eop: remove,
+			e:   e,
+		})
+	}
+	return true
+}
+
+func (m *lruMgr[V]) NotifyEvict() {
+	if m.overThreshold() && m.es.TryRun() {
 		m.notifyEvict()
 	}
 }
 
-func (m *lruMgr) notifyEvict() {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.EvictUnusedItem)()
-	op := &lruOp{
+func (m *lruMgr[V]) notifyEvict() {
+	defer m.trace.record(EvictUnusedItem, m.trace.start())
+	m.send(&lruOp{
 		eop: evict,
-	}
-	m.ops <- op
+	})
 }
 
-func (m *lruMgr) NotifyMoveToFront(key string) {
+func (m *lruMgr[V]) NotifyMoveToFront(key string) {
 	m.notifyMoveToFront(key)
 }
 
-func (m *lruMgr) notifyMoveToFront(key string) {
-	defer timeCost.DefaultTimeCostAnalyzer.DeferModuleTimeCost(timeCost.NotifyPushFront)()
+func (m *lruMgr[V]) notifyMoveToFront(key string) {
+	defer m.trace.record(NotifyPushFront, m.trace.start())
 	m.mu.RLock()
 	v, ok := m.items[key]
 	m.mu.RUnlock()
 	if !ok {
 		return
 	}
-	op := &lruOp{
-		eop: moveToFront,
-		e:   v,
+	m.sendMu.RLock()
+	defer m.sendMu.RUnlock()
+	if m.closed() {
+		m.opStats.dropped.Add(1)
+		return
+	}
+	// 移到栈顶只影响淘汰顺序，ops已满时丢弃，不让updater等待写入
+	select {
+	case m.ops <- &lruOp{eop: moveToFront, e: v}:
+	default:
+		m.opStats.dropped.Add(1)
+	}
+}
+
+// send Close后handleOp不再接收，丢弃操作而不是永远阻塞，返回是否入队。
+// 先检查done：两个case都就绪时select随机选择，Close后ops有空位时操作仍可能入队，却不会再被处理
+func (m *lruMgr[V]) send(op *lruOp) bool {
+	m.sendMu.RLock()
+	defer m.sendMu.RUnlock()
+	if m.closed() {
+		m.opStats.dropped.Add(1)
+		return false
+	}
+	select {
+	case m.ops <- op:
+		return true
+	case <-m.done:
+		m.opStats.dropped.Add(1)
+		return false
+	}
+}
+
+func (m *lruMgr[V]) closed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *lruMgr[V]) handleOp() {
+	defer m.wg.Done()
+	// 没有读缓冲时两者为nil，永远不会被选中
+	var drainCh chan struct{}
+	var drainTick <-chan time.Time
+	if m.reads != nil {
+		drainCh = m.reads.drainCh
+		drainTick = m.drainTicker.C
+	}
+	for {
+		select {
+		case op := <-m.ops:
+			m.handleBatch(m.nextBatch(op))
+		case <-drainCh:
+			m.drainReads()
+		case <-drainTick:
+			m.drainReads()
+		case <-m.done:
+			// 处理完已入队的操作再退出，已Add的元素与统计不会丢失
+			for {
+				select {
+				case op := <-m.ops:
+					m.handleBatch(m.nextBatch(op))
+				default:
+					return
+				}
+			}
+		}
 	}
-	m.ops <- op
 }
 
-func (m *lruMgr) handleOp() {
-	var now time.Time
-	for op := range m.ops {
+// nextBatch 在op之后不阻塞地取出已入队的操作，至多maxOpBatch个
+func (m *lruMgr[V]) nextBatch(op *lruOp) []*lruOp {
+	if depth := uint64(len(m.ops)) + 1; depth > m.opStats.maxDepth.Load() {
+		m.opStats.maxDepth.Store(depth)
+	}
+	batch := append(m.batch[:0], op)
+	for len(batch) < maxOpBatch {
+		select {
+		case op := <-m.ops:
+			batch = append(batch, op)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// handleBatch 按入队顺序处理一批操作，连续的添加、删除在一次持锁中完成
+func (m *lruMgr[V]) handleBatch(batch []*lruOp) {
+	m.opStats.batches.Add(1)
+	m.opStats.ops.Add(uint64(len(batch)))
+	m.coalesce(batch)
+	for i := 0; i < len(batch); {
+		op := batch[i]
+		switch {
+		case op == nil:
+			i++
+		case op.eop == add || op.eop == remove:
+			j := i + 1
+			for j < len(batch) && (batch[j] == nil || batch[j].eop == add || batch[j].eop == remove) {
+				j++
+			}
+			m.applyWrites(batch[i:j])
+			i = j
+		default:
+			m.handle(op)
+			i++
+		}
+	}
+	clear(batch)
+}
+
+// coalesce 同一元素的多次moveToFront只保留最后一次，被省去的置为nil
+// 清理可能移除栈底的元素，省去清理之前的moveToFront会改变被清理的元素，所以只在两次可能清理的操作之间合并
+func (m *lruMgr[V]) coalesce(batch []*lruOp) {
+	clear(m.seen)
+	for i := len(batch) - 1; i >= 0; i-- {
+		op := batch[i]
 		switch op.eop {
-		case add:
-			now = time.Now()
-			e := m.evictList.PushBack(op.v)
-			m.mu.Lock()
-			m.items[op.key] = e
-			m.mu.Unlock()
-			timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.AddItem, now)
+		case add, evict:
+			clear(m.seen)
 		case moveToFront:
-			m.evictList.MoveToFront(op.e)
-		case evict:
-			now = time.Now()
-			for m.evictList.Len() > m.safeThreshold {
-				back := m.evictList.Back()
-				m.evictList.Remove(back)
-				m.mu.Lock()
-				delete(m.items, back.Value.(*item).key)
-				m.mu.Unlock()
+			if _, ok := m.seen[op.e]; ok {
+				batch[i] = nil
+				m.opStats.coalesced.Add(1)
+				continue
 			}
-			m.es.SetState(idle)
-			timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.EvictUnusedItem, now)
+			m.seen[op.e] = struct{}{}
 		}
 	}
+	clear(m.seen)
+}
+
+func (m *lruMgr[V]) handle(op *lruOp) {
+	switch op.eop {
+	case moveToFront:
+		m.evictList.MoveToFront(op.e)
+	case evict:
+		m.evictUnused()
+		m.es.SetState(idle)
+	}
+}
+
+// applyWrites 在一次持锁中依次处理添加、删除，ops中可能有合并后的nil。只在handleOp中调用
+// 添加时从pending取出当前的元素移入items，入队后已被删除的跳过
+func (m *lruMgr[V]) applyWrites(ops []*lruOp) {
+	start := m.trace.start()
+	var added bool
+	// list.Remove会读取Value，与TryUpdate的替换同样需要加锁
+	m.mu.Lock()
+	for _, op := range ops {
+		if op == nil {
+			continue
+		}
+		if op.eop == remove {
+			m.evictList.Remove(op.e)
+			continue
+		}
+		i, ok := m.pending[op.key]
+		if !ok {
+			continue
+		}
+		delete(m.pending, op.key)
+		added = true
+		m.items[op.key] = m.evictList.PushBack(i)
+		atomic.AddInt64(&m.length, 1)
+		atomic.AddInt64(&m.weight, i.weight)
+	}
+	m.mu.Unlock()
+	if !added {
+		return
+	}
+	m.trace.record(AddItem, start)
+	// NotifyEvict在add被处理前判断阈值，连续添加后可能没有人再触发清理，这里补上
+	// 等待空位的Add通知的清理可能早于已预留的添加被处理，同样需要在这里补上
+	if m.overThreshold() || m.hasWaiters() {
+		m.evictUnused()
+	}
+}
+
+// RecordAccess 记录一次访问，不阻塞。没有读缓冲时忽略
+func (m *lruMgr[V]) RecordAccess(key string) {
+	if m.reads != nil {
+		m.reads.Record(key)
+	}
+}
+
+// drainReads 排空读缓冲，转交updater更新访问计数
+func (m *lruMgr[V]) drainReads() {
+	start := m.trace.start()
+	m.reads.Drain(m.reads.forwardTo(m.readCh))
+	m.trace.record(NotifyPushFront, start)
+}
+
+// Close 等待handleOp处理完已入队的操作并退出，可重复调用。
+// 与Close并发、在handleOp退出后才入队的操作不会再被处理，计入Dropped
+func (m *lruMgr[V]) Close() error {
+	m.closeOnce.Do(func() {
+		if m.drainTicker != nil {
+			m.drainTicker.Stop()
+		}
+		close(m.done)
+		m.wg.Wait()
+		// 阻塞在ops上的发送方会看到done而返回，之后再检查done的都会丢弃
+		m.sendMu.Lock()
+		defer m.sendMu.Unlock()
+		for {
+			select {
+			case op := <-m.ops:
+				m.opStats.dropped.Add(1)
+				if op.eop == add {
+					m.dropAdd(op.key)
+				}
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// evictUnused 从栈底清理到安全线之下，只在handleOp中调用
+// 每次持锁至多移除maxOpBatch个，回调在锁外进行
+func (m *lruMgr[V]) evictUnused() {
+	start := m.trace.start()
+	for {
+		evicted := m.evicted[:0]
+		m.mu.Lock()
+		for len(evicted) < maxOpBatch && m.evictList.Len() > 0 && m.overSafeThreshold() {
+			if i, ok := m.removeElementLocked(m.evictList.Back()); ok {
+				evicted = append(evicted, i)
+			}
+		}
+		m.mu.Unlock()
+		for _, i := range evicted {
+			m.notifyEvictCallback(i, ihe_lru.EvictByCapacity)
+		}
+		n := len(evicted)
+		clear(evicted)
+		m.evicted = evicted[:0]
+		if n < maxOpBatch {
+			break
+		}
+	}
+	m.trace.record(EvictUnusedItem, start)
+}
+
+// removeElementLocked 需持有写锁，只在handleOp中调用。元素已被Remove从items删除时只移出evictList，返回false
+func (m *lruMgr[V]) removeElementLocked(e *list.Element) (*item[V], bool) {
+	i := m.evictList.Remove(e).(*item[V])
+	if cur, ok := m.items[i.key]; !ok || cur != e {
+		return i, false
+	}
+	delete(m.items, i.key)
+	atomic.AddInt64(&m.length, -1)
+	m.releaseLocked()
+	atomic.AddInt64(&m.weight, -i.weight)
+	return i, true
+}
+
+// reserve 为新key预留位置，达到maxSize时按overflow处理，未能预留返回ErrFull
+// 预留包括已入队未处理的添加，所以后台处理再慢，items的元素个数也不会超过maxSize
+func (m *lruMgr[V]) reserve() error {
+	if m.maxSize <= 0 {
+		return nil
+	}
+	var deadline time.Time
+	for {
+		n := atomic.LoadInt64(&m.reserved)
+		if n < m.maxSize {
+			if atomic.CompareAndSwapInt64(&m.reserved, n, n+1) {
+				return nil
+			}
+			continue
+		}
+		// evictList只由handleOp维护，无法在调用方goroutine驱逐，OverflowEvict也只能等待后台驱逐，但不设超时
+		if m.overflow == OverflowReject || !m.waitForSpace(&deadline) {
+			m.stats.RecordRejection()
+			m.forceEvict()
+			return ErrFull
+		}
+	}
+}
+
+// releaseLocked 释放一个预留位置并唤醒等待者，需持有写锁
+func (m *lruMgr[V]) releaseLocked() {
+	if m.maxSize <= 0 {
+		return
+	}
+	atomic.AddInt64(&m.reserved, -1)
+	if m.spaceFreed != nil {
+		close(m.spaceFreed)
+		m.spaceFreed = nil
+	}
+}
+
+// waitForSpace 通知后台清理并等待释放预留位置。超时或已Close返回false
+func (m *lruMgr[V]) waitForSpace(deadline *time.Time) bool {
+	if m.overflow == OverflowBlock && m.overflowTimeout > 0 && deadline.IsZero() {
+		*deadline = time.Now().Add(m.overflowTimeout)
+	}
+	m.mu.Lock()
+	// 加锁前可能已有位置被释放，在锁内再判断一次才不会错过唤醒
+	if atomic.LoadInt64(&m.reserved) < m.maxSize {
+		m.mu.Unlock()
+		return true
+	}
+	if m.spaceFreed == nil {
+		m.spaceFreed = make(chan struct{})
+	}
+	freed := m.spaceFreed
+	m.mu.Unlock()
+	m.forceEvict()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(*deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-freed:
+		return true
+	case <-timeout:
+		return false
+	case <-m.done:
+		return false
+	}
+}
+
+// hasWaiters 是否有Add在等待空位
+func (m *lruMgr[V]) hasWaiters() bool {
+	if m.maxSize <= 0 {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.spaceFreed != nil
+}
+
+// forceEvict 不判断清理阈值直接通知清理。maxSize可能不大于threshold，只按阈值判断可能永远不会清理
+func (m *lruMgr[V]) forceEvict() {
+	if m.es.TryRun() {
+		m.notifyEvict()
+	}
+}
+
+// Resize 调整清理阈值，超出新阈值时通知后台清理
+func (m *lruMgr[V]) Resize(threshold, safeThreshold int) {
+	atomic.StoreInt64(&m.safeThreshold, int64(safeThreshold))
+	atomic.StoreInt64(&m.threshold, int64(threshold))
+	m.NotifyEvict()
+}
+
+func (m *lruMgr[V]) overThreshold() bool {
+	threshold := atomic.LoadInt64(&m.threshold)
+	return (threshold > 0 && atomic.LoadInt64(&m.length) > threshold) ||
+		(m.maxWeight > 0 && atomic.LoadInt64(&m.weight) > m.maxWeight)
+}
+
+func (m *lruMgr[V]) overSafeThreshold() bool {
+	threshold := atomic.LoadInt64(&m.threshold)
+	return (threshold > 0 && atomic.LoadInt64(&m.length) > atomic.LoadInt64(&m.safeThreshold)) ||
+		(m.maxWeight > 0 && atomic.LoadInt64(&m.weight) > m.safeWeight) ||
+		(m.maxSize > 0 && atomic.LoadInt64(&m.reserved) >= m.maxSize)
+}
+
+func (m *lruMgr[V]) weigh(key string, value V) int64 {
+	if m.weigher == nil {
+		return 1
+	}
+	return m.weigher(key, value)
+}
+
+// Len evictList只在handleOp中访问，这里以items与pending大小之和作为元素个数
+func (m *lruMgr[V]) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.items) + len(m.pending)
+}
+
+// Stats evictList只在handleOp中访问，这里以items与pending大小之和作为元素个数。pending中的元素尚未计入Weight
+func (m *lruMgr[V]) Stats() ihe_lru.Stats {
+	m.mu.RLock()
+	size := len(m.items) + len(m.pending)
+	m.mu.RUnlock()
+	return m.stats.Snapshot(size, atomic.LoadInt64(&m.weight))
+}
+
+// OpStats lruMgr后台处理操作的统计
+type OpStats struct {
+	// QueueDepth ops中待处理的操作个数，QueueCap ops的容量，MaxQueueDepth handleOp取操作时见过的最大积压
+	QueueDepth    int
+	QueueCap      int
+	MaxQueueDepth uint64
+	// Batches 处理的批次数，Ops 处理的操作个数，Ops/Batches即平均每批的操作个数
+	Batches uint64
+	Ops     uint64
+	// Coalesced 同一批中被后面的moveToFront覆盖而省去的moveToFront个数
+	Coalesced uint64
+	// Dropped ops已满时丢弃的moveToFront与Close后丢弃的操作个数
+	Dropped uint64
+	// DroppedReads 读缓冲写满、争用或转交updater时丢弃的访问个数
+	DroppedReads uint64
+}
+
+type opCounters struct {
+	maxDepth  atomic.Uint64
+	batches   atomic.Uint64
+	ops       atomic.Uint64
+	coalesced atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// OpStats 返回后台处理操作的统计，用于判断handleOp是否跟得上写入
+func (m *lruMgr[V]) OpStats() OpStats {
+	s := OpStats{
+		QueueDepth:    len(m.ops),
+		QueueCap:      cap(m.ops),
+		MaxQueueDepth: m.opStats.maxDepth.Load(),
+		Batches:       m.opStats.batches.Load(),
+		Ops:           m.opStats.ops.Load(),
+		Coalesced:     m.opStats.coalesced.Load(),
+		Dropped:       m.opStats.dropped.Load(),
+	}
+	if m.reads != nil {
+		s.DroppedReads = m.reads.dropped.Load()
+	}
+	return s
+}
+
+func (m *lruMgr[V]) notifyEvictCallback(i *item[V], reason ihe_lru.EvictReason) {
+	m.stats.RecordEviction(reason)
+	if m.onEvict != nil {
+		m.onEvict(i.key, i.value, reason)
+	}
 }
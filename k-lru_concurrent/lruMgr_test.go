@@ -0,0 +1,139 @@
+package k_lru_concurrent
+
+import (
+	"learn/ihe-lru"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// stoppedMgr handleOp已退出的lruMgr，测试直接调用handleBatch，结果确定
+func stoppedMgr(threshold, safeThreshold, optsSize int, opts Options[string]) *lruMgr[string] {
+	m := NewLRUMgrWithOptions(threshold, safeThreshold, optsSize, opts)
+	m.Close()
+	return m
+}
+
+// addOp 同NotifyAdd放入pending，返回待处理的add。key已存在时直接替换，返回nil
+func (m *lruMgr[V]) addOp(key string) *lruOp {
+	var v V
+	if !m.addPending(key, &item[V]{key: key, value: v, weight: 1}) {
+		return nil
+	}
+	return &lruOp{eop: add, key: key}
+}
+
+func (m *lruMgr[V]) moveOp(key string) *lruOp {
+	return &lruOp{eop: moveToFront, e: m.items[key]}
+}
+
+// keys 从栈顶到栈底的key
+func (m *lruMgr[V]) keys() []string {
+	var keys []string
+	for e := m.evictList.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*item[V]).key)
+	}
+	return keys
+}
+
+func TestCoalesceMgr(t *testing.T) {
+	m := stoppedMgr(10, 8, 100, Options[string]{})
+	m.handleBatch([]*lruOp{m.addOp("a"), m.addOp("b"), m.addOp("c")})
+
+	// 两次可能清理的操作之间，同一元素只保留最后一次moveToFront
+	m.handleBatch([]*lruOp{m.moveOp("c"), m.moveOp("b"), m.moveOp("c"), m.addOp("d"), m.moveOp("a"), m.moveOp("b"), m.moveOp("a")})
+	if want := []string{"a", "b", "c", "d"}; !slices.Equal(m.keys(), want) {
+		t.Fatalf("want %v, got %v", want, m.keys())
+	}
+	s := m.OpStats()
+	if s.Coalesced != 2 || s.Batches != 2 || s.Ops != 10 {
+		t.Fatalf("want 2 coalesced in 2 batches of 10 ops, got %+v", s)
+	}
+}
+
+func TestBatchWritesMgr(t *testing.T) {
+	evicted := make(map[ihe_lru.EvictReason]int)
+	m := stoppedMgr(10, 8, 100, Options[string]{OnEvict: func(key, value string, reason ihe_lru.EvictReason) {
+		evicted[reason]++
+	}})
+	n := maxOpBatch * 3
+	batch := make([]*lruOp, 0, n)
+	for i := 0; i < n; i++ {
+		batch = append(batch, m.addOp(strconv.Itoa(i)))
+	}
+	// 处理前重复添加同一个key，在pending中替换，不再入队
+	if m.addOp("0") != nil || m.addOp("0") != nil {
+		t.Fatal("pending key should be replaced in place")
+	}
+	m.handleBatch(batch)
+
+	// 多次持锁清理到安全线。新元素在栈底，最先被清理，留下最早添加的
+	if m.Len() != 8 || m.evictList.Len() != 8 {
+		t.Fatalf("want 8 items, got %d in items and %d in list", m.Len(), m.evictList.Len())
+	}
+	if want := []string{"0", "1", "2", "3", "4", "5", "6", "7"}; !slices.Equal(m.keys(), want) {
+		t.Fatalf("want %v, got %v", want, m.keys())
+	}
+	if evicted[ihe_lru.EvictByCapacity] != n-8 || evicted[ihe_lru.EvictByReplace] != 2 {
+		t.Fatalf("want %d capacity and 2 replace evictions, got %v", n-8, evicted)
+	}
+
+	// 删除与添加同在一次持锁中处理
+	m.Remove("7", ihe_lru.EvictByRemove)
+	m.handleBatch([]*lruOp{{eop: remove, e: m.evictList.Back()}, m.addOp("x")})
+	if m.Len() != 8 || m.evictList.Len() != 8 {
+		t.Fatalf("want 8 items, got %d in items and %d in list", m.Len(), m.evictList.Len())
+	}
+}
+
+// TestPendingMgr 添加后handleOp处理前即可见，此时删除的元素不会再被加入
+func TestPendingMgr(t *testing.T) {
+	m := stoppedMgr(10, 8, 100, Options[string]{})
+	op := m.addOp("a")
+	if _, ok := m.Get("a"); !ok || m.Len() != 1 {
+		t.Fatalf("a should be visible before handled, got len %d", m.Len())
+	}
+	if !m.Remove("a", ihe_lru.EvictByRemove) {
+		t.Fatal("remove pending a should return true")
+	}
+	m.handleBatch([]*lruOp{op})
+	if _, ok := m.Get("a"); ok || m.Len() != 0 || m.evictList.Len() != 0 {
+		t.Fatalf("removed a should not be added, got %d in items and %d in list", m.Len(), m.evictList.Len())
+	}
+}
+
+func TestDroppedMgr(t *testing.T) {
+	m := stoppedMgr(10, 8, 1, Options[string]{})
+	m.handleBatch([]*lruOp{m.addOp("a")})
+
+	// Close后的操作都丢弃，ops有空位也不入队，不会阻塞
+	m.NotifyMoveToFront("a")
+	m.NotifyMoveToFront("a")
+	m.NotifyAdd("b", &item[string]{key: "b", value: "b", weight: 1})
+
+	s := m.OpStats()
+	if s.Dropped != 3 || s.QueueDepth != 0 || s.QueueCap != 1 {
+		t.Fatalf("want 3 dropped and empty queue, got %+v", s)
+	}
+}
+
+// TestDroppedOnCloseMgr 与Close并发的操作要么被处理，要么计入Dropped，不会留在ops中
+func TestDroppedOnCloseMgr(t *testing.T) {
+	m := NewLRUMgrWithOptions(2000, 1600, 64, Options[string]{})
+	n := 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			key := strconv.Itoa(i)
+			m.NotifyAdd(key, &item[string]{key: key, value: key, weight: 1})
+		}
+	}()
+	m.Close()
+	<-done
+
+	s := m.OpStats()
+	if s.QueueDepth != 0 || s.Ops+s.Dropped != uint64(n) {
+		t.Fatalf("want %d ops processed or dropped and empty queue, got %+v", n, s)
+	}
+}
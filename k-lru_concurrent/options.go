@@ -0,0 +1,58 @@
+package k_lru_concurrent
+
+import (
+	"errors"
+	"learn/ihe-lru"
+	"time"
+)
+
+// ErrFull 达到MaxSize且按Overflow未能腾出空位，元素未被添加
+var ErrFull = errors.New("cache full")
+
+// OverflowPolicy 达到MaxSize时新增元素的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowEvict 同步驱逐栈底元素腾出空位，Add不会失败。clru的栈只由后台goroutine维护，Add等待后台驱逐完成
+	OverflowEvict OverflowPolicy = iota
+	// OverflowBlock 等待后台清理出空位，至多等待OverflowTimeout，超时返回ErrFull
+	OverflowBlock
+	// OverflowReject 立即返回ErrFull，并通知后台清理
+	OverflowReject
+)
+
+// Options 构造并发lru时的可选配置，零值即默认配置
+type Options[V any] struct {
+	// OnEvict 元素离开缓存时回调。调用时不持有缓存的锁，驱逐时在后台goroutine中调用
+	OnEvict func(key string, value V, reason ihe_lru.EvictReason)
+
+	// ExpireAfterWrite 元素写入后经过该时长过期，0表示不过期。AddWithTTL指定的ttl优先
+	ExpireAfterWrite time.Duration
+	// ExpireAfterAccess 元素最近一次访问后经过该时长过期，0表示不过期
+	ExpireAfterAccess time.Duration
+	// Now 获取当前时间，默认time.Now
+	Now func() time.Time
+
+	// Weigher 计算元素重量，比如value占用的字节数。默认每个元素重量为1
+	Weigher func(key string, value V) int64
+	// MaxWeight 缓存总重量上限，0表示不限制。与个数阈值一样超出后由后台清理到3/4，单个超过上限的元素不会被放入缓存
+	MaxWeight int64
+
+	// MaxSize 元素个数硬上限，任何时刻都不会超过，0表示不限制。size只是后台清理的阈值，添加太快时元素个数可能短暂超过size
+	// 后台清理跟不上、达到MaxSize时按Overflow处理，通常应大于size
+	MaxSize int
+	// Overflow 达到MaxSize时新增元素的处理方式，默认OverflowEvict
+	Overflow OverflowPolicy
+	// OverflowTimeout OverflowBlock时的最长等待时间，0表示一直等待直到有空位或Close
+	OverflowTimeout time.Duration
+
+	// Tracer 接收加锁、添加、清理等模块的耗时，默认NopTracer不做任何计时
+	Tracer Tracer
+}
+
+func (o Options[V]) now() func() time.Time {
+	if o.Now != nil {
+		return o.Now
+	}
+	return time.Now
+}
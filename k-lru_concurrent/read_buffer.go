@@ -0,0 +1,103 @@
+package k_lru_concurrent
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+const (
+	// readBufferStripes 条带数，Get随机选择条带，多核同时读时分散到不同条带
+	readBufferStripes = 16
+	// readBufferSize 每个条带的容量，须为2的幂
+	readBufferSize = 64
+	// readBufferDrainThreshold 条带积压到该数量时通知排空
+	readBufferDrainThreshold = readBufferSize / 2
+)
+
+// readBuffer 分条带的有损环形缓冲，仿照Caffeine的BoundedBuffer记录Get访问的key
+// 条带写满或CAS争用失败时直接丢弃这次访问，Get永不阻塞。访问只用于K-LRU的访问计数，丢失少量访问影响不大
+// 写入可以并发，排空只能由一个goroutine进行
+type readBuffer struct {
+	stripes [readBufferStripes]ringBuffer
+	// drainCh 容量1，通知维护goroutine排空
+	drainCh chan struct{}
+	// dropped 写满、争用或转交updater时ch已满而丢弃的访问次数
+	dropped atomic.Uint64
+}
+
+type ringBuffer struct {
+	// head 下一个读位置，只由排空推进；tail 下一个写位置，由写入方CAS推进
+	head  atomic.Uint64
+	tail  atomic.Uint64
+	slots [readBufferSize]atomic.Pointer[string]
+}
+
+func newReadBuffer() *readBuffer {
+	return &readBuffer{
+		drainCh: make(chan struct{}, 1),
+	}
+}
+
+// Record 记录一次访问，返回是否记录成功。条带积压到阈值或写满时通知排空
+func (b *readBuffer) Record(key string) bool {
+	r := &b.stripes[rand.Uint32()%readBufferStripes]
+	head := r.head.Load()
+	tail := r.tail.Load()
+	size := tail - head
+	if size >= readBufferSize {
+		b.dropped.Add(1)
+		b.scheduleDrain()
+		return false
+	}
+	// head只增不减，实际积压不会超过size，抢到的槽位一定已被排空
+	if !r.tail.CompareAndSwap(tail, tail+1) {
+		b.dropped.Add(1)
+		return false
+	}
+	r.slots[tail&(readBufferSize-1)].Store(&key)
+	if size+1 >= readBufferDrainThreshold {
+		b.scheduleDrain()
+	}
+	return true
+}
+
+func (b *readBuffer) scheduleDrain() {
+	select {
+	case b.drainCh <- struct{}{}:
+	default:
+	}
+}
+
+// Drain 依次排空各条带，对每个key调用f，返回排空的个数
+func (b *readBuffer) Drain(f func(key string)) int {
+	var n int
+	for i := range b.stripes {
+		r := &b.stripes[i]
+		head := r.head.Load()
+		tail := r.tail.Load()
+		for ; head < tail; head++ {
+			slot := &r.slots[head&(readBufferSize-1)]
+			key := slot.Load()
+			// 写入方已抢到槽位但还没写入，下次再读
+			if key == nil {
+				break
+			}
+			slot.Store(nil)
+			f(*key)
+			n++
+		}
+		r.head.Store(head)
+	}
+	return n
+}
+
+// forwardTo 返回把访问转交ch的函数。ch满时丢弃，与条带写满一样只影响访问计数的精度，维护goroutine不会因此阻塞
+func (b *readBuffer) forwardTo(ch chan string) func(key string) {
+	return func(key string) {
+		select {
+		case ch <- key:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
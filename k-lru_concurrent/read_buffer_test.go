@@ -0,0 +1,132 @@
+package k_lru_concurrent
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadBuffer(t *testing.T) {
+	b := newReadBuffer()
+	var recorded int
+	for i := 0; i < readBufferStripes*readBufferSize*2; i++ {
+		if b.Record(strconv.Itoa(i)) {
+			recorded++
+		}
+	}
+	if recorded > readBufferStripes*readBufferSize {
+		t.Fatalf("should drop when full, recorded %d", recorded)
+	}
+	if uint64(recorded)+b.dropped.Load() != readBufferStripes*readBufferSize*2 {
+		t.Fatalf("recorded %d dropped %d", recorded, b.dropped.Load())
+	}
+	select {
+	case <-b.drainCh:
+	default:
+		t.Fatal("should schedule drain")
+	}
+
+	seen := make(map[string]bool)
+	n := b.Drain(func(key string) {
+		seen[key] = true
+	})
+	if n != recorded || len(seen) != recorded {
+		t.Fatalf("want %d drained, got %d", recorded, n)
+	}
+	if n = b.Drain(func(string) {}); n != 0 {
+		t.Fatalf("should be empty, got %d", n)
+	}
+	// 排空后可以继续写入
+	if !b.Record("a") {
+		t.Fatal("should record after drain")
+	}
+}
+
+func TestReadBufferConcurrent(t *testing.T) {
+	b := newReadBuffer()
+	const goroutines, count = 8, 10000
+	var drained int
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				drained += b.Drain(func(string) {})
+				return
+			case <-b.drainCh:
+				drained += b.Drain(func(string) {})
+			}
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				b.Record(strconv.Itoa(i))
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+	if uint64(drained)+b.dropped.Load() != goroutines*count {
+		t.Fatalf("drained %d dropped %d, want total %d", drained, b.dropped.Load(), goroutines*count)
+	}
+}
+
+// TestGetNeverBlocksKLru 没有updater消费ch时，Get也不会阻塞
+func TestGetNeverBlocksKLru(t *testing.T) {
+	ch := make(chan string)
+	l := NewConcurrentLRU(10, ch)
+	defer l.Close()
+	c := NewCLRU(10, ch)
+	defer c.Close()
+	l.Add("a", "1")
+	c.Add("a", "1")
+	for c.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < readBufferStripes*readBufferSize*4; i++ {
+			l.Get("a")
+			c.Get("a")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("get blocked")
+	}
+}
+
+// BenchmarkGetParallel 热点key的并发读，访问记录不再经过channel
+func BenchmarkGetParallel(b *testing.B) {
+	size := 1000
+	ch := make(chan string, size*3)
+	l := NewConcurrentLRU(size, ch)
+	u := NewRecentUseUpdater(2, ch, l.MoveToFront, size*2, size*5)
+	u.Run()
+	defer u.Close()
+	defer l.Close()
+	for i := 0; i < size; i++ {
+		l.Add(strconv.Itoa(i), "v")
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			l.Get(strconv.Itoa(i % 16))
+			i++
+		}
+	})
+}
@@ -1,9 +1,7 @@
 package k_lru_concurrent
 
 import (
-	"learn/tool/timeCost"
 	"sync"
-	"time"
 )
 
 // 根据channel传过来的访问key，更新访问次数，在次数到达阈值时，将该key置于栈顶
@@ -18,7 +16,7 @@ import (
 // 进入死循环了啊。难道记录key、id的map不需要回收嘛？只能将key、id、count放到一起啦
 
 type recentUseUpdater struct {
-	// k > 1
+	// k > 1，NewKLRU会校验
 	k                  int
 	ch                 chan string
 	acm                *Acm
@@ -27,29 +25,53 @@ type recentUseUpdater struct {
 	id                 int64
 	cleanCh            chan struct{}
 	mu                 sync.Mutex
+	trace              trace
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 func NewRecentUseUpdater(k int, ch chan string, moveToFront func(key string), lowThreshold, highThreshold int) *recentUseUpdater {
+	return NewRecentUseUpdaterWithTracer(k, ch, moveToFront, lowThreshold, highThreshold, NopTracer{})
+}
+
+// NewRecentUseUpdaterWithTracer 同NewRecentUseUpdater，访问计数更新、清理、top-K的耗时交给tracer
+func NewRecentUseUpdaterWithTracer(k int, ch chan string, moveToFront func(key string), lowThreshold, highThreshold int, tracer Tracer) *recentUseUpdater {
+	t := newTrace(tracer)
 	return &recentUseUpdater{
 		k:  k,
 		ch: ch,
 		acm: &Acm{
 			counts: make(map[string]*accessCount),
 			k:      highThreshold - lowThreshold,
+			trace:  t,
 		},
 		moveToFront: moveToFront,
-		// assume highThreshold > lowThreshold
+		// 需highThreshold > lowThreshold，NewKLRU会校验
 		cleanHighThreshold: highThreshold,
 		cleanCh:            make(chan struct{}, 1),
 		mu:                 sync.Mutex{},
+		trace:              t,
+		done:               make(chan struct{}),
 	}
 }
 
 func (u *recentUseUpdater) Run() {
+	u.wg.Add(2)
 	go u.update()
 	go u.clean()
 }
 
+// Close 停止update、clean并等待其退出，可重复调用。ch属于调用方，不会被关闭，应先Close使用ch的缓存
+func (u *recentUseUpdater) Close() error {
+	u.closeOnce.Do(func() {
+		close(u.done)
+		u.wg.Wait()
+	})
+	return nil
+}
+
 // ?1 如果chan堵塞了怎么办呢？这难道用k-lru不是最好的嘛？k-lru当然也会堵塞。chan堵塞就等待呗
 // ?2 删除缓存元素时难道不应该删除counts嘛？当然应该。但通过什么方式知道那些元素已经被删除了呢？难道remover定期提供一个删除调的元素，让updater去删？
 // 可是刚刚删除的，可能后面又添加了啊！
@@ -59,56 +81,69 @@ func (u *recentUseUpdater) Run() {
 
 // 如果改成一次获取大量chan元素，可能会导致特定情况下到达批量时间过长。而且我想不到批量真的能够提升很大的速度嘛？除了Lock外其他很难说很快
 func (u *recentUseUpdater) update() {
-	for key := range u.ch {
-		now := time.Now()
-		u.mu.Lock()
-		timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.AcquireUpdateAccessCountLock, now)
-		u.id++
-		// 1. 不存在key，新建
-		if _, ok := u.acm.Get(key); !ok {
-			ac := &accessCount{
-				count: 1,
-				id:    u.id,
-			}
-			u.acm.Set(key, ac)
-			u.mu.Unlock()
-			timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.UpdateAccessCount, now)
-			continue
+	defer u.wg.Done()
+	for {
+		select {
+		case key := <-u.ch:
+			u.updateKey(key)
+		case <-u.done:
+			return
 		}
+	}
+}
 
-		if u.acm.CountLen() > u.cleanHighThreshold && len(u.cleanCh) == 0 {
-			u.cleanCh <- struct{}{}
+func (u *recentUseUpdater) updateKey(key string) {
+	start := u.trace.start()
+	u.mu.Lock()
+	u.trace.record(AcquireUpdateAccessCountLock, start)
+	u.id++
+	// 1. 不存在key，新建
+	if _, ok := u.acm.Get(key); !ok {
+		ac := &accessCount{
+			count: 1,
+			id:    u.id,
 		}
+		u.acm.Set(key, ac)
+		u.mu.Unlock()
+		u.trace.record(UpdateAccessCount, start)
+		return
+	}
 
-		// 2. 更新访问次数
-		na := time.Now()
-		u.acm.IncreaseAccessCount(key)
-		u.acm.SetID(key, u.id)
+	if u.acm.CountLen() > u.cleanHighThreshold && len(u.cleanCh) == 0 {
+		u.cleanCh <- struct{}{}
+	}
 
-		// 3. 若访问次数达到阈值，置于栈顶
-		// 置于栈顶后应该重置为0
-		if u.acm.GetAccessCount(key) > u.k {
-			u.acm.ResetAccessCount(key)
-			u.moveToFront(key)
-		}
-		u.mu.Unlock()
-		timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.AddAccessCount, na)
-		timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.UpdateAccessCount, now)
+	// 2. 更新访问次数
+	na := u.trace.start()
+	u.acm.IncreaseAccessCount(key)
+	u.acm.SetID(key, u.id)
+
+	// 3. 若访问次数达到阈值，置于栈顶
+	// 置于栈顶后应该重置为0
+	if u.acm.GetAccessCount(key) > u.k {
+		u.acm.ResetAccessCount(key)
+		u.moveToFront(key)
 	}
+	u.mu.Unlock()
+	u.trace.record(AddAccessCount, na)
+	u.trace.record(UpdateAccessCount, start)
 }
 
 func (u *recentUseUpdater) clean() {
+	defer u.wg.Done()
 	for {
 		select {
+		case <-u.done:
+			return
 		case <-u.cleanCh:
-			now := time.Now()
+			start := u.trace.start()
 			u.mu.Lock()
 			ks := u.acm.TopKByQuickSort()
 			for _, k := range ks {
 				u.acm.Delete(k.key)
 			}
 			u.mu.Unlock()
-			timeCost.DefaultTimeCostAnalyzer.RecordTimeCost(timeCost.CleanAccessCount, now)
+			u.trace.record(CleanAccessCount, start)
 		}
 	}
 }
@@ -0,0 +1,209 @@
+package k_lru_concurrent
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"learn/ihe-lru"
+	"runtime"
+)
+
+// 按key的哈希分片，每个分片是独立的并发K-LRU，各自有锁、后台清理与访问计数updater
+// lruConcurrent的Add几乎全部耗时都在等待同一把锁上，分片后不同分片的Add互不影响
+
+// defaultShardsPerProc 未设置分片数时，每个P对应的分片数
+const defaultShardsPerProc = 4
+
+// ShardedOptions NewShardedKLRU的配置，零值字段即默认配置
+type ShardedOptions[V any] struct {
+	// Shards 分片数，向上取为2的幂。默认GOMAXPROCS的4倍，且不超过Size
+	Shards int
+	// Async 分片使用clru，添加、清理都交给后台goroutine；否则使用lruConcurrent。clru不支持过期
+	Async bool
+	// KLRUOptions 其中Size、MaxWeight、MaxSize为全部分片的总和，平均分给各分片：Size向上取整，
+	// MaxWeight、MaxSize是硬上限，向下取整，保证总和不超过设置值；其余配置各分片相同
+	// key不会完全均匀地分布，单个分片满了就会清理，总元素个数可能小于Size
+	KLRUOptions[V]
+}
+
+// shard 分片的方法，lruConcurrent与clru都实现了
+type shard[V any] interface {
+	Get(key string) (V, bool)
+	GetOrLoad(ctx context.Context, key string, loader ihe_lru.Loader[string, V]) (V, error)
+	TryAdd(key string, value V) error
+	Remove(key string) bool
+	Len() int
+	Stats() ihe_lru.Stats
+	MoveToFront(key string)
+	Close() error
+}
+
+type shardedKLRU[V any] struct {
+	shards   []shard[V]
+	updaters []*recentUseUpdater
+	// seed 每个实例随机，key到分片的映射无法被外部预测
+	seed maphash.Seed
+	mask uint64
+}
+
+// NewShardedKLRU 创建分片的并发K-LRU
+func NewShardedKLRU[V any](opts ShardedOptions[V]) (*shardedKLRU[V], error) {
+	n, err := opts.shards()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Async && (opts.ExpireAfterWrite > 0 || opts.ExpireAfterAccess > 0) {
+		return nil, fmt.Errorf("%w: async shards have no expiry", ErrInvalidOptions)
+	}
+	so := opts.KLRUOptions
+	so.Size = ceilDiv(so.Size, n)
+	// 硬上限向下取整，分到每个分片不足1时无法满足
+	if so.MaxWeight > 0 {
+		if so.MaxWeight /= int64(n); so.MaxWeight < 1 {
+			return nil, fmt.Errorf("%w: max weight %d less than %d shards", ErrInvalidOptions, opts.MaxWeight, n)
+		}
+	}
+	if so.MaxSize > 0 {
+		if so.MaxSize /= n; so.MaxSize < 1 {
+			return nil, fmt.Errorf("%w: max size %d less than %d shards", ErrInvalidOptions, opts.MaxSize, n)
+		}
+	}
+	if err := so.fill(); err != nil {
+		return nil, err
+	}
+
+	s := &shardedKLRU[V]{
+		shards:   make([]shard[V], n),
+		updaters: make([]*recentUseUpdater, n),
+		seed:     maphash.MakeSeed(),
+		mask:     uint64(n - 1),
+	}
+	for i := range s.shards {
+		s.shards[i], s.updaters[i] = newShard(so, opts.Async)
+	}
+	return s, nil
+}
+
+// shards 分片数，2的幂
+func (o *ShardedOptions[V]) shards() (int, error) {
+	if o.Shards < 0 {
+		return 0, fmt.Errorf("%w: negative shards %d", ErrInvalidOptions, o.Shards)
+	}
+	if o.Shards > 0 {
+		n := nextPowerOfTwo(o.Shards)
+		// 每个分片至少能放一个元素
+		if o.Size > 0 && n > o.Size {
+			return 0, fmt.Errorf("%w: %d shards exceed size %d", ErrInvalidOptions, n, o.Size)
+		}
+		return n, nil
+	}
+	n := nextPowerOfTwo(runtime.GOMAXPROCS(0) * defaultShardsPerProc)
+	for o.Size > 0 && n > o.Size {
+		n >>= 1
+	}
+	return n, nil
+}
+
+// newShard 创建分片并启动为它更新访问计数的updater，opts已补全默认值
+func newShard[V any](opts KLRUOptions[V], async bool) (shard[V], *recentUseUpdater) {
+	ch := make(chan string, opts.ChanSize)
+	var s shard[V]
+	if async {
+		s = NewCLRUWithOptions(opts.Size, ch, opts.Options)
+	} else {
+		s = NewConcurrentLRUWithOptions(opts.Size, ch, opts.Options)
+	}
+	u := NewRecentUseUpdaterWithTracer(opts.K, ch, s.MoveToFront, opts.LowThreshold, opts.HighThreshold, opts.Tracer)
+	u.Run()
+	return s, u
+}
+
+func (s *shardedKLRU[V]) shard(key string) shard[V] {
+	return s.shards[maphash.String(s.seed, key)&s.mask]
+}
+
+func (s *shardedKLRU[V]) Get(key string) (V, bool) {
+	return s.shard(key).Get(key)
+}
+
+// GetOrLoad 由key所在分片加载，同一key并发未命中只会执行一次loader
+func (s *shardedKLRU[V]) GetOrLoad(ctx context.Context, key string, loader ihe_lru.Loader[string, V]) (V, error) {
+	return s.shard(key).GetOrLoad(ctx, key, loader)
+}
+
+func (s *shardedKLRU[V]) Add(key string, value V) {
+	s.shard(key).TryAdd(key, value)
+}
+
+// TryAdd 同Add，返回ErrFull表示所在分片达到MaxSize而未添加，见Overflow
+func (s *shardedKLRU[V]) TryAdd(key string, value V) error {
+	return s.shard(key).TryAdd(key, value)
+}
+
+// Set 同Add，供ihe_lru.Cache使用
+func (s *shardedKLRU[V]) Set(key string, value V) {
+	s.Add(key, value)
+}
+
+// Remove 删除key对应元素，返回是否存在
+func (s *shardedKLRU[V]) Remove(key string) bool {
+	return s.shard(key).Remove(key)
+}
+
+// Len 各分片元素个数之和，逐个分片读取，并发修改时不是同一时刻的快照
+func (s *shardedKLRU[V]) Len() int {
+	var n int
+	for _, sh := range s.shards {
+		n += sh.Len()
+	}
+	return n
+}
+
+// Stats 汇总各分片的统计，与Len一样不是同一时刻的快照
+func (s *shardedKLRU[V]) Stats() ihe_lru.Stats {
+	var st ihe_lru.Stats
+	st.Evictions = make(map[ihe_lru.EvictReason]uint64)
+	for _, sh := range s.shards {
+		ss := sh.Stats()
+		st.Hits += ss.Hits
+		st.Misses += ss.Misses
+		st.Loads += ss.Loads
+		st.LoadFailures += ss.LoadFailures
+		st.Rejections += ss.Rejections
+		for r, n := range ss.Evictions {
+			st.Evictions[r] += n
+		}
+		st.Size += ss.Size
+		st.Weight += ss.Weight
+	}
+	return st
+}
+
+// Close 先关闭全部分片，再停止各自的updater，可重复调用
+func (s *shardedKLRU[V]) Close() error {
+	var err error
+	for _, sh := range s.shards {
+		if e := sh.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for _, u := range s.updaters {
+		u.Close()
+	}
+	return err
+}
+
+func ceilDiv(a, b int) int {
+	if a <= 0 {
+		return a
+	}
+	return (a + b - 1) / b
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
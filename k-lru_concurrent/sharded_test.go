@@ -0,0 +1,196 @@
+package k_lru_concurrent
+
+import (
+	"errors"
+	"learn/ihe-lru"
+	"learn/ihe-lru/cachetest"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+var _ ihe_lru.Cache[string, string] = (*shardedKLRU[string])(nil)
+
+func TestShardedKLRU(t *testing.T) {
+	cachetest.CheckLeaks(t)
+	size := 64
+	l, err := NewShardedKLRU(ShardedOptions[string]{Shards: 4, KLRUOptions: KLRUOptions[string]{Size: size}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if len(l.shards) != 4 {
+		t.Fatalf("want 4 shards, got %d", len(l.shards))
+	}
+
+	for i := 0; i < size*4; i++ {
+		key := strconv.Itoa(i)
+		l.Add(key, key)
+		if v, ok := l.Get(key); !ok || v != key {
+			t.Fatalf("want %s, got %s %v", key, v, ok)
+		}
+	}
+	waitUntilLen(l, size)
+	if n := l.Len(); n > size {
+		t.Fatalf("want len <= %d, got %d", size, n)
+	}
+	// 每个分片各自清理，不超过自己的容量
+	for i, sh := range l.shards {
+		if n := sh.Len(); n > size/4 {
+			t.Fatalf("shard %d: want len <= %d, got %d", i, size/4, n)
+		}
+	}
+
+	// 同一key总在同一分片，且key分散到了各个分片
+	used := make(map[shard[string]]bool)
+	for i := 0; i < size*4; i++ {
+		key := strconv.Itoa(i)
+		if l.shard(key) != l.shard(key) {
+			t.Fatalf("key %s moved between shards", key)
+		}
+		used[l.shard(key)] = true
+	}
+	if len(used) != len(l.shards) {
+		t.Fatalf("want keys in all %d shards, got %d", len(l.shards), len(used))
+	}
+
+	st := l.Stats()
+	if st.Hits != uint64(size*4) || st.Size != l.Len() {
+		t.Fatalf("want %d hits and size %d, got %+v", size*4, l.Len(), st)
+	}
+	if st.Evictions[ihe_lru.EvictByCapacity] == 0 {
+		t.Fatalf("want capacity evictions, got %+v", st.Evictions)
+	}
+}
+
+func TestShardedKLRUShards(t *testing.T) {
+	cases := []struct {
+		opts ShardedOptions[string]
+		want int
+	}{
+		{ShardedOptions[string]{Shards: 3, KLRUOptions: KLRUOptions[string]{Size: 10}}, 4},
+		{ShardedOptions[string]{Shards: 8, KLRUOptions: KLRUOptions[string]{Size: 8}}, 8},
+		// 默认分片数不超过Size
+		{ShardedOptions[string]{KLRUOptions: KLRUOptions[string]{Size: 1}}, 1},
+		{ShardedOptions[string]{Shards: 2, KLRUOptions: KLRUOptions[string]{Options: Options[string]{MaxWeight: 100}}}, 2},
+	}
+	for _, c := range cases {
+		n, err := c.opts.shards()
+		if err != nil || n != c.want {
+			t.Errorf("%+v: want %d shards, got %d %v", c.opts, c.want, n, err)
+		}
+	}
+}
+
+// TestShardedKLRUHardLimits MaxSize、MaxWeight向下取整分给各分片，总和不超过设置值
+func TestShardedKLRUHardLimits(t *testing.T) {
+	s, err := NewShardedKLRU(ShardedOptions[string]{Shards: 4, KLRUOptions: KLRUOptions[string]{
+		Size:    8,
+		Options: Options[string]{MaxSize: 10, MaxWeight: 103},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	var maxSize, maxWeight int64
+	for _, sh := range s.shards {
+		l := sh.(*lruConcurrent[string])
+		maxSize += int64(l.maxSize)
+		maxWeight += l.maxWeight
+	}
+	if maxSize != 8 || maxWeight != 100 {
+		t.Fatalf("want total max size 8 and max weight 100, got %d %d", maxSize, maxWeight)
+	}
+}
+
+func TestShardedKLRUInvalid(t *testing.T) {
+	cases := map[string]ShardedOptions[string]{
+		"no size":             {},
+		"negative shards":     {Shards: -1, KLRUOptions: KLRUOptions[string]{Size: 10}},
+		"shards > size":       {Shards: 16, KLRUOptions: KLRUOptions[string]{Size: 10}},
+		"max weight < shards": {Shards: 4, KLRUOptions: KLRUOptions[string]{Size: 10, Options: Options[string]{MaxWeight: 3}}},
+		"max size < shards":   {Shards: 4, KLRUOptions: KLRUOptions[string]{Size: 10, Options: Options[string]{MaxSize: 3}}},
+		"k":                   {KLRUOptions: KLRUOptions[string]{Size: 10, K: 1}},
+		"async expiry":        {Async: true, KLRUOptions: KLRUOptions[string]{Size: 10, Options: Options[string]{ExpireAfterWrite: 1}}},
+	}
+	for name, opts := range cases {
+		if _, err := NewShardedKLRU(opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: want ErrInvalidOptions, got %v", name, err)
+		}
+	}
+}
+
+func TestShardedKLRUConformance(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(strconv.FormatBool(async), func(t *testing.T) {
+			cachetest.RunConformanceWithOptions(t, func(cfg cachetest.Config) (ihe_lru.Cache[string, string], error) {
+				// 2的幂整除Size，总容量与Size一致
+				return NewShardedKLRU(ShardedOptions[string]{Shards: 4, Async: async, KLRUOptions: KLRUOptions[string]{
+					Size: cfg.Size,
+					Options: Options[string]{
+						OnEvict:          cfg.OnEvict,
+						ExpireAfterWrite: cfg.ExpireAfterWrite,
+						Now:              cfg.Now,
+					},
+				}})
+			}, cachetest.Options{Async: true, SkipExpiry: async})
+		})
+	}
+}
+
+// BenchmarkAddParallel 随机key的并发添加，单个lruConcurrent所有Add争用同一把锁，分片后各分片的Add互不影响
+func BenchmarkAddParallel(b *testing.B) {
+	size := 10000
+	benchmarkParallel(b, size, func(l benchCache, r *rand.Rand) {
+		key := strconv.Itoa(r.IntN(size * 4))
+		l.TryAdd(key, key)
+	})
+}
+
+// BenchmarkMixedParallel 读多写少，Get命中时也要在Add持锁期间等待读锁
+func BenchmarkMixedParallel(b *testing.B) {
+	size := 10000
+	benchmarkParallel(b, size, func(l benchCache, r *rand.Rand) {
+		key := strconv.Itoa(r.IntN(size * 2))
+		if r.IntN(10) < 8 {
+			l.Get(key)
+			return
+		}
+		l.TryAdd(key, key)
+	})
+}
+
+// benchCache 基准测试用到的方法，kLRU与shardedKLRU都实现了
+type benchCache interface {
+	Get(key string) (string, bool)
+	TryAdd(key string, value string) error
+}
+
+func benchmarkParallel(b *testing.B, size int, op func(l benchCache, r *rand.Rand)) {
+	b.Run("single", func(b *testing.B) {
+		l, err := NewKLRU(KLRUOptions[string]{Size: size})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer l.Close()
+		runParallel(b, l, op)
+	})
+	b.Run("sharded", func(b *testing.B) {
+		l, err := NewShardedKLRU(ShardedOptions[string]{KLRUOptions: KLRUOptions[string]{Size: size}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer l.Close()
+		runParallel(b, l, op)
+	})
+}
+
+func runParallel(b *testing.B, l benchCache, op func(l benchCache, r *rand.Rand)) {
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(rand.Uint64(), 0))
+		for pb.Next() {
+			op(l, r)
+		}
+	})
+}
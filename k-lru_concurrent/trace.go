@@ -0,0 +1,118 @@
+package k_lru_concurrent
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Event 可被追踪耗时的模块
+type Event int
+
+const (
+	GetItem Event = iota
+	AddItem
+	AcquireAddItemLock
+	RealAddItem
+	EvictUnusedItem
+	AcquireEvictUnusedItemLock
+	NotifyPushFront
+	AcquireUpdateAccessCountLock
+	UpdateAccessCount
+	AddAccessCount
+	CleanAccessCount
+	TopKByQuickSort
+
+	eventCount
+)
+
+var eventNames = [eventCount]string{
+	GetItem:                      "GetItem",
+	AddItem:                      "AddItem",
+	AcquireAddItemLock:           "AcquireAddItemLock",
+	RealAddItem:                  "RealAddItem",
+	EvictUnusedItem:              "EvictUnusedItem",
+	AcquireEvictUnusedItemLock:   "AcquireEvictUnusedItemLock",
+	NotifyPushFront:              "NotifyPushFront",
+	AcquireUpdateAccessCountLock: "AcquireUpdateAccessCountLock",
+	UpdateAccessCount:            "UpdateAccessCount",
+	AddAccessCount:               "AddAccessCount",
+	CleanAccessCount:             "CleanAccessCount",
+	TopKByQuickSort:              "TopKByQuickSort",
+}
+
+func (e Event) String() string {
+	if e >= 0 && e < eventCount {
+		return eventNames[e]
+	}
+	return fmt.Sprintf("Event(%d)", int(e))
+}
+
+// Tracer 接收各模块耗时，会被多个goroutine并发调用
+type Tracer interface {
+	// Record 记录事件e自start起的耗时
+	Record(e Event, start time.Time)
+}
+
+// NopTracer 什么也不做。未设置Tracer时的默认值，此时连当前时间也不会获取
+type NopTracer struct{}
+
+func (NopTracer) Record(Event, time.Time) {}
+
+// trace 对Tracer的包装，没有真正的Tracer时start、record都直接返回，生产环境不付出计时开销
+// 用法: defer l.trace.record(GetItem, l.trace.start())
+type trace struct {
+	t Tracer
+}
+
+func newTrace(t Tracer) trace {
+	if _, ok := t.(NopTracer); ok {
+		return trace{}
+	}
+	return trace{t: t}
+}
+
+func (t trace) start() time.Time {
+	if t.t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (t trace) record(e Event, start time.Time) {
+	if t.t != nil {
+		t.t.Record(e, start)
+	}
+}
+
+// CostTracer 累计各模块的次数与总耗时，用于耗时实验
+type CostTracer struct {
+	counts [eventCount]int64
+	costs  [eventCount]int64
+}
+
+func NewCostTracer() *CostTracer {
+	return &CostTracer{}
+}
+
+func (c *CostTracer) Record(e Event, start time.Time) {
+	if e < 0 || e >= eventCount {
+		return
+	}
+	atomic.AddInt64(&c.counts[e], 1)
+	atomic.AddInt64(&c.costs[e], int64(time.Since(start)))
+}
+
+// Cost 返回模块累计的次数与总耗时
+func (c *CostTracer) Cost(e Event) (count int64, cost time.Duration) {
+	return atomic.LoadInt64(&c.counts[e]), time.Duration(atomic.LoadInt64(&c.costs[e]))
+}
+
+// OutputResult 打印有记录的模块的总耗时
+func (c *CostTracer) OutputResult() {
+	for e := Event(0); e < eventCount; e++ {
+		if count, cost := c.Cost(e); count > 0 {
+			fmt.Printf("module: %s cost: %s\n", e, cost)
+		}
+	}
+}
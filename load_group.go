@@ -0,0 +1,76 @@
+package ihe_lru
+
+import (
+	"context"
+	"sync"
+)
+
+// Loader 缓存未命中时从其他数据源加载key对应的value
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// LoadGroup 合并同一key的并发加载：同一时间每个key只有一个加载在执行，其余调用方等待并共享其结果。零值可直接使用
+type LoadGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+	// waiters 仍在等待结果的调用方个数，全部放弃后取消加载
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Do 执行key对应的加载fn，已有加载在执行时等待其结果
+// ctx取消时当前调用方立即返回ctx.Err()；所有调用方都放弃后，传给fn的ctx随之取消
+func (g *LoadGroup[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*loadCall[V])
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		// 加载不应随首个调用方的取消而取消，只保留其中的value
+		lctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &loadCall[V]{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		g.calls[key] = c
+		go g.load(lctx, key, c, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			// 之后的调用方重新加载，不再等待已被取消的加载
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (g *LoadGroup[K, V]) load(ctx context.Context, key K, c *loadCall[V], fn func(ctx context.Context) (V, error)) {
+	c.value, c.err = fn(ctx)
+
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	c.cancel()
+	close(c.done)
+}
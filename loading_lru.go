@@ -0,0 +1,143 @@
+package ihe_lru
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoadingLRU 线程安全的LRU，并提供GetOrLoad实现缓存未命中时从其他数据源加载
+type LoadingLRU[K comparable, V any] interface {
+	LRU[K, V]
+
+	// GetOrLoad 缓存命中直接返回；未命中时调用loader加载并加入缓存。同一key并发未命中只会执行一次loader
+	// loader返回错误时不会缓存，错误返回给所有等待的调用方。ctx取消时返回ctx.Err()
+	GetOrLoad(ctx context.Context, key K, loader Loader[K, V]) (V, error)
+}
+
+type loadingLRU[K comparable, V any] struct {
+	mu    sync.Mutex
+	l     LRU[K, V]
+	loads LoadGroup[K, V]
+	// stats 只记录加载，其余统计来自l
+	stats StatsCounter
+}
+
+// NewLoadingLRU 包装l，之后对l的访问都应通过返回值进行
+func NewLoadingLRU[K comparable, V any](l LRU[K, V]) LoadingLRU[K, V] {
+	return &loadingLRU[K, V]{
+		l: l,
+	}
+}
+
+func (l *loadingLRU[K, V]) GetOrLoad(ctx context.Context, key K, loader Loader[K, V]) (V, error) {
+	if v, ok := l.Get(key); ok {
+		return v, nil
+	}
+
+	return l.loads.Do(ctx, key, func(ctx context.Context) (V, error) {
+		// 等待期间可能已被其他加载放入缓存。用Peek再查一次，调用方的Get已记过未命中，不能重复计入统计
+		if v, ok := l.Peek(key); ok {
+			return v, nil
+		}
+		v, err := loader(ctx, key)
+		l.stats.RecordLoad(err)
+		if err != nil {
+			return v, err
+		}
+		l.Add(key, v)
+		return v, nil
+	})
+}
+
+func (l *loadingLRU[K, V]) Get(key K) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Get(key)
+}
+
+func (l *loadingLRU[K, V]) Add(key K, value V) (K, V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Add(key, value)
+}
+
+// Set 同Add，供Cache使用
+func (l *loadingLRU[K, V]) Set(key K, value V) {
+	l.Add(key, value)
+}
+
+func (l *loadingLRU[K, V]) AddWithTTL(key K, value V, ttl time.Duration) (K, V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.AddWithTTL(key, value, ttl)
+}
+
+func (l *loadingLRU[K, V]) Remove(key K) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Remove(key)
+}
+
+func (l *loadingLRU[K, V]) Peek(key K) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Peek(key)
+}
+
+func (l *loadingLRU[K, V]) Contains(key K) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Contains(key)
+}
+
+func (l *loadingLRU[K, V]) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Len()
+}
+
+func (l *loadingLRU[K, V]) Keys() []K {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Keys()
+}
+
+func (l *loadingLRU[K, V]) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.l.Purge()
+}
+
+func (l *loadingLRU[K, V]) RemoveOldest() (K, V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.RemoveOldest()
+}
+
+func (l *loadingLRU[K, V]) GetOldest() (K, V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.GetOldest()
+}
+
+func (l *loadingLRU[K, V]) Resize(size int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Resize(size)
+}
+
+func (l *loadingLRU[K, V]) Stats() Stats {
+	l.mu.Lock()
+	s := l.l.Stats()
+	l.mu.Unlock()
+	ls := l.stats.Snapshot(0, 0)
+	s.Loads += ls.Loads
+	s.LoadFailures += ls.LoadFailures
+	return s
+}
+
+// Close 没有后台goroutine，无需释放
+func (l *loadingLRU[K, V]) Close() error {
+	return nil
+}
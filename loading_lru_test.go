@@ -0,0 +1,134 @@
+package ihe_lru
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoadCoalesce(t *testing.T) {
+	l := NewLoadingLRU[string, string](NewGeneralLRU[string, string](10))
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "world", nil
+	}
+
+	count := 10
+	wg := &sync.WaitGroup{}
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			v, err := l.GetOrLoad(context.Background(), "hello", loader)
+			if err != nil || v != "world" {
+				t.Errorf("unexpected result %v %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("loader should run once, got %d", calls)
+	}
+	if v, ok := l.Get("hello"); !ok || v != "world" {
+		t.Fatal("loaded value should be cached")
+	}
+}
+
+// TestGetOrLoadStats 每个未命中的key只记一次未命中、一次加载，再次获取记为命中
+func TestGetOrLoadStats(t *testing.T) {
+	l := NewLoadingLRU[string, string](NewGeneralLRU[string, string](10))
+	loader := func(ctx context.Context, key string) (string, error) {
+		return key, nil
+	}
+	keys := []string{"a", "b", "c"}
+	for _, key := range keys {
+		if _, err := l.GetOrLoad(context.Background(), key, loader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := l.Stats(); s.Misses != 3 || s.Loads != 3 || s.Hits != 0 {
+		t.Fatalf("want 3 misses and 3 loads, got %+v", s)
+	}
+	for _, key := range keys {
+		l.GetOrLoad(context.Background(), key, loader)
+	}
+	if s := l.Stats(); s.Misses != 3 || s.Loads != 3 || s.Hits != 3 {
+		t.Fatalf("want 3 hits after load, got %+v", s)
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	l := NewLoadingLRU[string, string](NewGeneralLRU[string, string](10))
+	errDB := errors.New("db down")
+	_, err := l.GetOrLoad(context.Background(), "hello", func(ctx context.Context, key string) (string, error) {
+		return "", errDB
+	})
+	if err != errDB {
+		t.Fatalf("should propagate loader error, got %v", err)
+	}
+	if l.Contains("hello") {
+		t.Fatal("error should not be cached")
+	}
+	if s := l.Stats(); s.Loads != 1 || s.LoadFailures != 1 {
+		t.Fatalf("bad loads %d failures %d", s.Loads, s.LoadFailures)
+	}
+}
+
+func TestGetOrLoadCancel(t *testing.T) {
+	l := NewLoadingLRU[string, string](NewGeneralLRU[string, string](10))
+	loaderCanceled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := l.GetOrLoad(ctx, "hello", func(ctx context.Context, key string) (string, error) {
+		<-ctx.Done()
+		close(loaderCanceled)
+		return "", ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Fatalf("should be canceled, got %v", err)
+	}
+
+	// the only waiter gave up, so the loader is canceled as well
+	select {
+	case <-loaderCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("loader should be canceled")
+	}
+}
+
+func TestSyncCache(t *testing.T) {
+	var c Cache[string, int] = NewSyncCache[string, int](NewGeneralLRU[string, int](2))
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should be evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Fatalf("want c 3, got %v %v", v, ok)
+	}
+	if !c.Remove("b") || c.Remove("b") {
+		t.Fatal("b should be removed once")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("want len 1, got %d", c.Len())
+	}
+	if s := c.Stats(); s.Evictions[EvictByCapacity] != 1 || s.Evictions[EvictByRemove] != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,9 +1,46 @@
 package ihe_lru
 
-type LRU interface {
+import "time"
+
+// LRU 固定大小的lru缓存，key、value类型由使用方指定
+type LRU[K comparable, V any] interface {
 	// Get 返回key对应lru内容，以及是否存在
-	Get(key string) (string, bool)
+	Get(key K) (V, bool)
+
+	// Add 添加lru内容，key已存在时更新value。返回是否因此驱逐了元素，以及被驱逐的key、value
+	// 按重量驱逐时可能驱逐多个元素，仅返回第一个，全部被驱逐元素可通过Options.OnEvict获得
+	Add(key K, value V) (evictedKey K, evictedValue V, evicted bool)
+
+	// AddWithTTL 同Add，且元素在ttl后过期。过期元素对Get不可见
+	AddWithTTL(key K, value V, ttl time.Duration) (evictedKey K, evictedValue V, evicted bool)
+
+	// Remove 删除key对应内容，返回是否存在
+	Remove(key K) bool
+
+	// Peek 返回key对应内容，但不更新最近访问
+	Peek(key K) (V, bool)
+
+	// Contains 判断key是否存在，不更新最近访问
+	Contains(key K) bool
+
+	// Len 返回缓存元素个数
+	Len() int
+
+	// Keys 返回全部key，从最近访问到最久未访问
+	Keys() []K
+
+	// Purge 清空缓存
+	Purge()
+
+	// RemoveOldest 删除最久未访问的元素并返回
+	RemoveOldest() (K, V, bool)
+
+	// GetOldest 返回最久未访问的元素，不更新最近访问
+	GetOldest() (K, V, bool)
+
+	// Resize 调整元素个数上限，缩小时从最久未访问开始驱逐，返回被驱逐的个数
+	Resize(size int) (evicted int)
 
-	// Add 添加lru内容
-	Add(key, value string)
+	// Stats 返回命中、驱逐等统计
+	Stats() Stats
 }
@@ -1,64 +1,312 @@
 package ihe_lru
 
-import "container/list"
+import (
+	"container/heap"
+	"container/list"
+	"time"
+)
 
 // 想达到什么效果，提供什么功能？
 // 设想这样的场景，首先从缓存中查，若缓存存在，则将该key置于驱逐栈顶（也就是最后删除）。若不存在，从其他数据源查询，并加入到缓存
+// （这一流程由LoadingLRU.GetOrLoad提供，并合并同一key的并发查询）
 // 提供一个固定大小的lru缓存，能够添加缓存（添加的缓存视为最近使用的，驱逐栈溢出时，溢出栈底元素），访问缓存（访问后的置于驱逐栈顶），
-type item struct {
-	key   string
-	value string
+type item[K comparable, V any] struct {
+	key   K
+	value V
+	// writeDeadline 写入时确定的过期时间，expireAt 考虑访问后实际的过期时间。零值表示不过期
+	writeDeadline time.Time
+	expireAt      time.Time
+	// index 在过期堆中的位置，不在堆中为-1
+	index  int
+	weight int64
 }
 
-type lru struct {
+type lru[K comparable, V any] struct {
 	// 难道真的要将key、value全部放到element嘛。(关键在于删除map元素操作需要key，可是list中没有)这也就意味着即使是自己实现的evictList也必须要为element添加key、且value
 	// 那能不能element只添加key呢？那value储存在哪里呢？
-	items     map[string]*list.Element
+	items     map[K]*list.Element
 	evictList *list.List
 	size      int
+	onEvict   func(key K, value V, reason EvictReason)
+
+	expireAfterWrite  time.Duration
+	expireAfterAccess time.Duration
+	now               func() time.Time
+	expiry            expiryHeap[K, V]
+
+	weigher   func(key K, value V) int64
+	maxWeight int64
+	weight    int64
+
+	stats StatsCounter
+}
+
+func NewGeneralLRU[K comparable, V any](size int) LRU[K, V] {
+	return NewGeneralLRUWithOptions[K, V](size, Options[K, V]{})
 }
 
-func NewGeneralLRU(size int) LRU {
-	return &lru{
-		items:     make(map[string]*list.Element),
+// NewGeneralLRUWithOptions size为元素个数上限，小于等于0表示不限制个数，仅受opts.MaxWeight限制
+func NewGeneralLRUWithOptions[K comparable, V any](size int, opts Options[K, V]) LRU[K, V] {
+	return &lru[K, V]{
+		items:     make(map[K]*list.Element),
 		evictList: list.New(),
 		size:      size,
+		onEvict:   opts.OnEvict,
+
+		expireAfterWrite:  opts.ExpireAfterWrite,
+		expireAfterAccess: opts.ExpireAfterAccess,
+		now:               opts.now(),
+
+		weigher:   opts.Weigher,
+		maxWeight: opts.MaxWeight,
 	}
 }
 
-func (l *lru) Get(key string) (string, bool) {
+func (l *lru[K, V]) Get(key K) (V, bool) {
+	l.removeExpired()
+
 	// 1. 查看是否在缓存中存在
 	i, ok := l.items[key]
 	if !ok {
-		return "", false
+		l.stats.RecordMiss()
+		var zero V
+		return zero, false
 	}
+	l.stats.RecordHit()
 
-	// 2. 对于存在元素置于evict栈顶
+	// 2. 对于存在元素置于evict栈顶，并顺延过期时间
 	l.evictList.MoveToFront(i)
+	it := i.Value.(*item[K, V])
+	if l.expireAfterAccess > 0 {
+		l.setExpireAt(it, deadline(it.writeDeadline, l.now().Add(l.expireAfterAccess)))
+	}
 
 	// 3. 返回查出来的元素
-	return i.Value.(*item).value, true
+	return it.value, true
 }
 
-func (l *lru) Add(key, value string) {
-	// 1. 若添加元素在里面，则置于栈顶。
-	_, exists := l.Get(key)
-	if exists {
-		return
+// Add 添加或更新元素，返回因添加而被驱逐的元素
+func (l *lru[K, V]) Add(key K, value V) (evictedKey K, evictedValue V, evicted bool) {
+	return l.AddWithTTL(key, value, l.expireAfterWrite)
+}
+
+// AddWithTTL 添加或更新元素，元素在ttl后过期，ttl为0表示不过期
+func (l *lru[K, V]) AddWithTTL(key K, value V, ttl time.Duration) (evictedKey K, evictedValue V, evicted bool) {
+	l.removeExpired()
+
+	var writeDeadline, expireAt time.Time
+	if ttl > 0 || l.expireAfterAccess > 0 {
+		now := l.now()
+		if ttl > 0 {
+			writeDeadline = now.Add(ttl)
+		}
+		expireAt = writeDeadline
+		if l.expireAfterAccess > 0 {
+			expireAt = deadline(writeDeadline, now.Add(l.expireAfterAccess))
+		}
 	}
 
-	// 2. 若添加元素不在，且大小达到限制，则删除栈底元素
-	if l.evictList.Len() >= l.size {
-		back := l.evictList.Back()
-		l.evictList.Remove(back)
-		delete(l.items, back.Value.(*item).key)
+	// 单个元素就超过重量限制，不可能放入缓存，视为立即被驱逐
+	w := l.weigh(key, value)
+	if l.maxWeight > 0 && w > l.maxWeight {
+		if e, ok := l.items[key]; ok {
+			l.removeElement(e, EvictByReplace)
+		}
+		l.notifyEvict(key, value, EvictByCapacity)
+		return key, value, true
 	}
 
-	// 3. 添加该元素并置于栈顶
-	i := &item{
-		key:   key,
-		value: value,
+	// 1. 若添加元素在里面，则更新value并置于栈顶。
+	if e, ok := l.items[key]; ok {
+		i := e.Value.(*item[K, V])
+		old := i.value
+		i.value = value
+		i.writeDeadline = writeDeadline
+		l.weight += w - i.weight
+		i.weight = w
+		l.setExpireAt(i, expireAt)
+		l.evictList.MoveToFront(e)
+		l.notifyEvict(key, old, EvictByReplace)
+		return l.evictOverflow()
 	}
+
+	// 2. 添加该元素并置于栈顶
+	i := &item[K, V]{
+		key:           key,
+		value:         value,
+		writeDeadline: writeDeadline,
+		weight:        w,
+		index:         -1,
+	}
+	l.setExpireAt(i, expireAt)
 	e := l.evictList.PushFront(i)
 	l.items[key] = e
+	l.weight += w
+
+	// 3. 若大小、重量达到限制，则删除栈底元素
+	return l.evictOverflow()
+}
+
+// evictOverflow 从栈底驱逐元素直到满足大小、重量限制。返回第一个被驱逐的元素
+func (l *lru[K, V]) evictOverflow() (evictedKey K, evictedValue V, evicted bool) {
+	for l.overflow() {
+		back := l.evictList.Back()
+		i := back.Value.(*item[K, V])
+		l.removeElement(back, EvictByCapacity)
+		if !evicted {
+			evictedKey, evictedValue, evicted = i.key, i.value, true
+		}
+	}
+	return
+}
+
+func (l *lru[K, V]) overflow() bool {
+	return (l.size > 0 && l.evictList.Len() > l.size) || (l.maxWeight > 0 && l.weight > l.maxWeight)
+}
+
+func (l *lru[K, V]) weigh(key K, value V) int64 {
+	if l.weigher == nil {
+		return 1
+	}
+	return l.weigher(key, value)
+}
+
+func (l *lru[K, V]) Remove(key K) bool {
+	l.removeExpired()
+	e, ok := l.items[key]
+	if !ok {
+		return false
+	}
+	l.removeElement(e, EvictByRemove)
+	return true
+}
+
+func (l *lru[K, V]) Peek(key K) (V, bool) {
+	l.removeExpired()
+	e, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return e.Value.(*item[K, V]).value, true
+}
+
+func (l *lru[K, V]) Contains(key K) bool {
+	l.removeExpired()
+	_, ok := l.items[key]
+	return ok
+}
+
+func (l *lru[K, V]) Len() int {
+	l.removeExpired()
+	return l.evictList.Len()
+}
+
+func (l *lru[K, V]) Keys() []K {
+	l.removeExpired()
+	keys := make([]K, 0, l.evictList.Len())
+	for e := l.evictList.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*item[K, V]).key)
+	}
+	return keys
+}
+
+func (l *lru[K, V]) Purge() {
+	l.removeExpired()
+	for e := l.evictList.Back(); e != nil; e = e.Prev() {
+		i := e.Value.(*item[K, V])
+		l.notifyEvict(i.key, i.value, EvictByRemove)
+	}
+	l.items = make(map[K]*list.Element)
+	l.evictList.Init()
+	l.expiry = nil
+	l.weight = 0
+}
+
+func (l *lru[K, V]) RemoveOldest() (K, V, bool) {
+	l.removeExpired()
+	back := l.evictList.Back()
+	if back == nil {
+		var (
+			zk K
+			zv V
+		)
+		return zk, zv, false
+	}
+	l.removeElement(back, EvictByRemove)
+	i := back.Value.(*item[K, V])
+	return i.key, i.value, true
+}
+
+func (l *lru[K, V]) GetOldest() (K, V, bool) {
+	l.removeExpired()
+	back := l.evictList.Back()
+	if back == nil {
+		var (
+			zk K
+			zv V
+		)
+		return zk, zv, false
+	}
+	i := back.Value.(*item[K, V])
+	return i.key, i.value, true
+}
+
+func (l *lru[K, V]) Resize(size int) (evicted int) {
+	l.removeExpired()
+	l.size = size
+	for l.overflow() {
+		l.removeElement(l.evictList.Back(), EvictByCapacity)
+		evicted++
+	}
+	return
+}
+
+func (l *lru[K, V]) removeElement(e *list.Element, reason EvictReason) {
+	l.evictList.Remove(e)
+	i := e.Value.(*item[K, V])
+	delete(l.items, i.key)
+	l.weight -= i.weight
+	if i.index >= 0 {
+		heap.Remove(&l.expiry, i.index)
+	}
+	l.notifyEvict(i.key, i.value, reason)
+}
+
+// setExpireAt 更新元素过期时间，并维护其在过期堆中的位置
+func (l *lru[K, V]) setExpireAt(i *item[K, V], expireAt time.Time) {
+	i.expireAt = expireAt
+	switch {
+	case expireAt.IsZero() && i.index >= 0:
+		heap.Remove(&l.expiry, i.index)
+	case expireAt.IsZero():
+	case i.index >= 0:
+		heap.Fix(&l.expiry, i.index)
+	default:
+		heap.Push(&l.expiry, i)
+	}
+}
+
+// removeExpired 删除所有已过期的元素。lru本身并非线程安全，无法在后台goroutine中清理，于是每个方法开始时都从过期堆顶开始清理
+// 过期元素不会等到被访问或到达栈底才回收，Len等统计不包括它们，Remove、Resize、Purge也不会把它们当作删除或容量驱逐
+func (l *lru[K, V]) removeExpired() {
+	if len(l.expiry) == 0 {
+		return
+	}
+	now := l.now()
+	for len(l.expiry) > 0 && !l.expiry[0].expireAt.After(now) {
+		l.removeElement(l.items[l.expiry[0].key], EvictByExpire)
+	}
+}
+
+func (l *lru[K, V]) Stats() Stats {
+	l.removeExpired()
+	return l.stats.Snapshot(l.evictList.Len(), l.weight)
+}
+
+func (l *lru[K, V]) notifyEvict(key K, value V, reason EvictReason) {
+	l.stats.RecordEviction(reason)
+	if l.onEvict != nil {
+		l.onEvict(key, value, reason)
+	}
 }
@@ -0,0 +1,28 @@
+package ihe_lru
+
+import "time"
+
+// Options 构造缓存时的可选配置，零值即默认配置
+type Options[K comparable, V any] struct {
+	// OnEvict 元素离开缓存时回调，reason说明离开的原因。可用于释放与元素绑定的外部资源
+	OnEvict func(key K, value V, reason EvictReason)
+
+	// ExpireAfterWrite 元素写入后经过该时长过期，0表示不过期。AddWithTTL指定的ttl优先
+	ExpireAfterWrite time.Duration
+	// ExpireAfterAccess 元素最近一次访问后经过该时长过期，0表示不过期。与写入过期同时设置时，先到者生效
+	ExpireAfterAccess time.Duration
+	// Now 获取当前时间，默认time.Now。测试时可替换为假时钟
+	Now func() time.Time
+
+	// Weigher 计算元素重量，比如value占用的字节数。默认每个元素重量为1
+	Weigher func(key K, value V) int64
+	// MaxWeight 缓存总重量上限，0表示不限制。超出时从最久未访问开始驱逐直到满足上限，单个超过上限的元素不会被放入缓存
+	MaxWeight int64
+}
+
+func (o Options[K, V]) now() func() time.Time {
+	if o.Now != nil {
+		return o.Now
+	}
+	return time.Now
+}
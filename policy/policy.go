@@ -0,0 +1,130 @@
+// Package policy 按名字选择淘汰策略，构造统一的ihe_lru.Cache
+// 业务只依赖ihe_lru.Cache，淘汰策略写在配置里，切换时不需要改代码
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"learn/ihe-lru"
+	"learn/ihe-lru/ihelfu"
+	"learn/ihe-lru/k-lru_concurrent"
+	"learn/ihe-lru/tinylfu"
+	"strings"
+	"time"
+)
+
+const (
+	// LRU 根包的lru，加互斥锁
+	LRU = "lru"
+	// TinyLFU W-TinyLFU，加互斥锁
+	TinyLFU = "tinylfu"
+	// LRUConcurrent 并发K-LRU，访问k次才移到栈顶
+	LRUConcurrent = "lruConcurrent"
+	// CLRU 并发K-LRU，添加、清理都交给后台goroutine
+	CLRU = "clru"
+	// SegIheLfu 分段的ihe lfu
+	SegIheLfu = "segIheLfu"
+)
+
+// names 按此顺序输出
+var names = []string{LRU, TinyLFU, LRUConcurrent, CLRU, SegIheLfu}
+
+// Names 返回全部策略名
+func Names() []string {
+	return append([]string(nil), names...)
+}
+
+var (
+	ErrUnknownPolicy = errors.New("policy: unknown policy")
+	ErrUnsupported   = errors.New("policy: option not supported")
+)
+
+// Config 构造缓存的配置，零值字段即默认配置
+type Config[V any] struct {
+	// Policy 淘汰策略名，见Names
+	Policy string
+	// Size 元素个数上限，小于等于0表示不限制个数，此时必须设置MaxWeight
+	Size int
+
+	// OnEvict 元素离开缓存时回调。并发实现中在后台goroutine调用
+	OnEvict func(key string, value V, reason ihe_lru.EvictReason)
+
+	// ExpireAfterWrite 元素写入后经过该时长过期，0表示不过期。clru不支持
+	ExpireAfterWrite time.Duration
+	// ExpireAfterAccess 元素最近一次访问后经过该时长过期，0表示不过期。clru不支持
+	ExpireAfterAccess time.Duration
+	// Now 获取当前时间，默认time.Now
+	Now func() time.Time
+
+	// Weigher 计算元素重量，默认每个元素重量为1。segIheLfu不支持
+	Weigher func(key string, value V) int64
+	// MaxWeight 缓存总重量上限，0表示不限制。segIheLfu不支持
+	MaxWeight int64
+}
+
+// New 按cfg.Policy构造缓存
+func New[V any](cfg Config[V]) (ihe_lru.Cache[string, V], error) {
+	if cfg.Size <= 0 && cfg.MaxWeight <= 0 {
+		return nil, fmt.Errorf("policy: size or max weight is required, got size %d", cfg.Size)
+	}
+
+	switch cfg.Policy {
+	case LRU:
+		return ihe_lru.NewSyncCache(ihe_lru.NewGeneralLRUWithOptions(cfg.Size, rootOptions(cfg))), nil
+	case TinyLFU:
+		return ihe_lru.NewSyncCache(tinylfu.NewCacheWithOptions(cfg.Size, rootOptions(cfg))), nil
+	case LRUConcurrent:
+		l, err := k_lru_concurrent.NewKLRU(k_lru_concurrent.KLRUOptions[V]{Size: cfg.Size, Options: klruOptions(cfg)})
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	case CLRU:
+		if cfg.ExpireAfterWrite > 0 || cfg.ExpireAfterAccess > 0 {
+			return nil, fmt.Errorf("%w: %s has no expiry", ErrUnsupported, cfg.Policy)
+		}
+		l, err := k_lru_concurrent.NewAsyncKLRU(k_lru_concurrent.KLRUOptions[V]{Size: cfg.Size, Options: klruOptions(cfg)})
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	case SegIheLfu:
+		if cfg.Weigher != nil || cfg.MaxWeight > 0 {
+			return nil, fmt.Errorf("%w: %s has no weight", ErrUnsupported, cfg.Policy)
+		}
+		l, err := ihelfu.NewSegIheLfuWithOptions(cfg.Size, ihelfu.Options[V]{
+			OnEvict:           cfg.OnEvict,
+			ExpireAfterWrite:  cfg.ExpireAfterWrite,
+			ExpireAfterAccess: cfg.ExpireAfterAccess,
+			Now:               cfg.Now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("%w %q, want one of %s", ErrUnknownPolicy, cfg.Policy, strings.Join(names, ", "))
+	}
+}
+
+func rootOptions[V any](cfg Config[V]) ihe_lru.Options[string, V] {
+	return ihe_lru.Options[string, V]{
+		OnEvict:           cfg.OnEvict,
+		ExpireAfterWrite:  cfg.ExpireAfterWrite,
+		ExpireAfterAccess: cfg.ExpireAfterAccess,
+		Now:               cfg.Now,
+		Weigher:           cfg.Weigher,
+		MaxWeight:         cfg.MaxWeight,
+	}
+}
+
+func klruOptions[V any](cfg Config[V]) k_lru_concurrent.Options[V] {
+	return k_lru_concurrent.Options[V]{
+		OnEvict:           cfg.OnEvict,
+		ExpireAfterWrite:  cfg.ExpireAfterWrite,
+		ExpireAfterAccess: cfg.ExpireAfterAccess,
+		Now:               cfg.Now,
+		Weigher:           cfg.Weigher,
+		MaxWeight:         cfg.MaxWeight,
+	}
+}
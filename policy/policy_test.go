@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"errors"
+	"learn/ihe-lru"
+	"learn/ihe-lru/cachetest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// eventually 并发实现的添加是异步的，等待f成立
+func eventually(t *testing.T, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			var evicted []string
+			c, err := New(Config[string]{
+				Policy: name,
+				Size:   10,
+				OnEvict: func(key, value string, reason ihe_lru.EvictReason) {
+					if reason == ihe_lru.EvictByRemove {
+						evicted = append(evicted, key)
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			c.Set("a", "1")
+			eventually(t, func() bool {
+				v, ok := c.Get("a")
+				return ok && v == "1"
+			})
+			c.Set("a", "2")
+			eventually(t, func() bool {
+				v, _ := c.Get("a")
+				return v == "2"
+			})
+			if !c.Remove("a") {
+				t.Fatal("a should exist")
+			}
+			if _, ok := c.Get("a"); ok {
+				t.Fatal("a should be removed")
+			}
+			if len(evicted) != 1 || evicted[0] != "a" {
+				t.Fatalf("want remove callback for a, got %v", evicted)
+			}
+
+			// segIheLfu的容量由各区域定期清理保证，小容量时并不严格
+			if name != SegIheLfu {
+				for i := 0; i < 100; i++ {
+					c.Set(strconv.Itoa(i), "v")
+				}
+				eventually(t, func() bool {
+					return c.Len() <= 10
+				})
+			}
+			if s := c.Stats(); s.Hits == 0 || s.Misses == 0 {
+				t.Fatalf("unexpected stats %+v", s)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New(Config[string]{Policy: "nope", Size: 10}); !errors.Is(err, ErrUnknownPolicy) {
+		t.Fatalf("want ErrUnknownPolicy, got %v", err)
+	}
+	if _, err := New(Config[string]{Policy: LRU}); err == nil {
+		t.Fatal("should require size")
+	}
+	_, err := New(Config[string]{Policy: SegIheLfu, Size: 10, MaxWeight: 100})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("want ErrUnsupported, got %v", err)
+	}
+	_, err = New(Config[string]{Policy: CLRU, Size: 10, ExpireAfterWrite: time.Minute})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("want ErrUnsupported, got %v", err)
+	}
+}
+
+// conformanceOptions 各策略与一般缓存不同的地方，新策略默认按同步、严格容量检查
+var conformanceOptions = map[string]cachetest.Options{
+	LRUConcurrent: {Async: true},
+	CLRU:          {Async: true, SkipExpiry: true},
+	SegIheLfu:     {Async: true},
+}
+
+func TestConformance(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			cachetest.RunConformanceWithOptions(t, func(cfg cachetest.Config) (ihe_lru.Cache[string, string], error) {
+				return New(Config[string]{
+					Policy:           name,
+					Size:             cfg.Size,
+					OnEvict:          cfg.OnEvict,
+					ExpireAfterWrite: cfg.ExpireAfterWrite,
+					Now:              cfg.Now,
+				})
+			}, conformanceOptions[name])
+		})
+	}
+}
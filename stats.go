@@ -0,0 +1,112 @@
+package ihe_lru
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Stats 缓存统计快照
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Loads 通过GetOrLoad执行加载的次数，LoadFailures 其中失败的次数
+	Loads        uint64
+	LoadFailures uint64
+	// Rejections 达到个数硬上限而未能添加的次数
+	Rejections uint64
+	// Evictions 按原因统计离开缓存的元素个数
+	Evictions map[EvictReason]uint64
+	// Size 当前元素个数，Weight 当前总重量
+	Size   int
+	Weight int64
+}
+
+// HitRatio 命中率，没有访问时为0
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// StatsCounter 供各缓存实现记录统计，零值可直接使用
+// 每个计数分散到多个独占cache line的槽中，并发累加时随机选槽，避免所有goroutine争用同一个原子变量
+type StatsCounter struct {
+	hits         stripedCounter
+	misses       stripedCounter
+	loads        stripedCounter
+	loadFailures stripedCounter
+	rejections   stripedCounter
+	evictions    [evictReasonCount]stripedCounter
+}
+
+func (c *StatsCounter) RecordHit() {
+	c.hits.add(1)
+}
+
+func (c *StatsCounter) RecordMiss() {
+	c.misses.add(1)
+}
+
+// RecordLoad 记录一次加载，err不为nil视为加载失败
+func (c *StatsCounter) RecordLoad(err error) {
+	c.loads.add(1)
+	if err != nil {
+		c.loadFailures.add(1)
+	}
+}
+
+// RecordRejection 记录一次因缓存已满被拒绝的添加
+func (c *StatsCounter) RecordRejection() {
+	c.rejections.add(1)
+}
+
+func (c *StatsCounter) RecordEviction(reason EvictReason) {
+	if reason >= 0 && reason < evictReasonCount {
+		c.evictions[reason].add(1)
+	}
+}
+
+// Snapshot 汇总当前计数，size、weight由缓存实现提供
+func (c *StatsCounter) Snapshot(size int, weight int64) Stats {
+	s := Stats{
+		Hits:         c.hits.sum(),
+		Misses:       c.misses.sum(),
+		Loads:        c.loads.sum(),
+		LoadFailures: c.loadFailures.sum(),
+		Rejections:   c.rejections.sum(),
+		Evictions:    make(map[EvictReason]uint64, evictReasonCount),
+		Size:         size,
+		Weight:       weight,
+	}
+	for r := EvictReason(0); r < evictReasonCount; r++ {
+		if n := c.evictions[r].sum(); n > 0 {
+			s.Evictions[r] = n
+		}
+	}
+	return s
+}
+
+const counterStripes = 8
+
+type paddedCounter struct {
+	n atomic.Uint64
+	_ [56]byte
+}
+
+type stripedCounter struct {
+	cells [counterStripes]paddedCounter
+}
+
+func (c *stripedCounter) add(n uint64) {
+	c.cells[rand.Uint32()%counterStripes].n.Add(n)
+}
+
+func (c *stripedCounter) sum() uint64 {
+	var total uint64
+	for i := range c.cells {
+		total += c.cells[i].n.Load()
+	}
+	return total
+}
@@ -1 +1,471 @@
 package tinylfu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"learn/ihe-lru"
+	"sync"
+	"time"
+)
+
+// binlog 记录缓存的访问流，离线回放以比较不同策略
+// 格式: 头部 "IHEBLOG" + 版本号1字节 + key hash的seed 8字节小端，之后每条记录为
+//   op 1字节 | 与上一条记录的时间差(纳秒，varint) | key hash 8字节小端(purge为0) | 仅add: weight(uvarint)
+// 只追加写，不记录key、value本身。key hash只由seed与key决定，同一seed的binlog之间、与其他进程可以比较。
+// 版本1没有seed，hash使用进程内随机的seed，只能在同一个binlog内比较
+
+type Op uint8
+
+const (
+	OpGet Op = iota + 1
+	OpAdd
+	OpRemove
+	// OpPurge 清空缓存
+	OpPurge
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpGet:
+		return "get"
+	case OpAdd:
+		return "add"
+	case OpRemove:
+		return "remove"
+	case OpPurge:
+		return "purge"
+	default:
+		return fmt.Sprintf("Op(%d)", uint8(o))
+	}
+}
+
+// Record 一次缓存访问
+type Record struct {
+	Op   Op
+	Time time.Time
+	Hash uint64
+	// Weight 只有add有意义
+	Weight int64
+}
+
+const (
+	binlogMagic   = "IHEBLOG"
+	binlogVersion = 2
+	// DefaultBinlogSeed NewBinlogWriter使用的seed
+	DefaultBinlogSeed uint64 = 0
+)
+
+var ErrBadBinlog = errors.New("tinylfu: bad binlog")
+
+// BinlogWriter 写binlog，可并发Write。写出错后之后的Write都返回同一个错误
+type BinlogWriter struct {
+	mu   sync.Mutex
+	w    *bufio.Writer
+	seed uint64
+	last int64
+	buf  [1 + 2*binary.MaxVarintLen64 + 8]byte
+	err  error
+}
+
+// NewBinlogWriter 以DefaultBinlogSeed写入头部，返回的BinlogWriter需要Flush后数据才完整
+func NewBinlogWriter(w io.Writer) (*BinlogWriter, error) {
+	return NewBinlogWriterWithSeed(w, DefaultBinlogSeed)
+}
+
+// NewBinlogWriterWithSeed 同NewBinlogWriter，Recorder以seed计算key hash
+func NewBinlogWriterWithSeed(w io.Writer, seed uint64) (*BinlogWriter, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(binlogMagic); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(binlogVersion); err != nil {
+		return nil, err
+	}
+	if _, err := bw.Write(binary.LittleEndian.AppendUint64(nil, seed)); err != nil {
+		return nil, err
+	}
+	return &BinlogWriter{w: bw, seed: seed}, nil
+}
+
+// Seed 写入头部的key hash的seed
+func (b *BinlogWriter) Seed() uint64 {
+	return b.seed
+}
+
+func (b *BinlogWriter) Write(r Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+
+	ts := r.Time.UnixNano()
+	buf := b.buf[:0]
+	buf = append(buf, byte(r.Op))
+	// 并发写入时时间不一定递增，差值可能为负
+	buf = binary.AppendVarint(buf, ts-b.last)
+	buf = binary.LittleEndian.AppendUint64(buf, r.Hash)
+	if r.Op == OpAdd {
+		buf = binary.AppendUvarint(buf, uint64(r.Weight))
+	}
+	b.last = ts
+
+	_, b.err = b.w.Write(buf)
+	return b.err
+}
+
+func (b *BinlogWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.err = b.w.Flush()
+	return b.err
+}
+
+// Err 返回第一次写入失败的错误
+func (b *BinlogWriter) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// BinlogReader 顺序读取binlog
+type BinlogReader struct {
+	r       *bufio.Reader
+	version byte
+	seed    uint64
+	last    int64
+}
+
+// NewBinlogReader 校验头部，兼容版本1
+func NewBinlogReader(r io.Reader) (*BinlogReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(binlogMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBinlog, err)
+	}
+	if string(header[:len(binlogMagic)]) != binlogMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrBadBinlog)
+	}
+	b := &BinlogReader{r: br, version: header[len(binlogMagic)]}
+	switch b.version {
+	case 1:
+	case binlogVersion:
+		var seed [8]byte
+		if _, err := io.ReadFull(br, seed[:]); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadBinlog, err)
+		}
+		b.seed = binary.LittleEndian.Uint64(seed[:])
+	default:
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadBinlog, b.version)
+	}
+	return b, nil
+}
+
+// Seed 返回记录key hash使用的seed，用HashBinlogKey可以算出某个key在binlog中的hash。
+// 版本1没有记录seed，返回false
+func (b *BinlogReader) Seed() (uint64, bool) {
+	return b.seed, b.version != 1
+}
+
+// Read 读取下一条记录，读完时返回io.EOF，记录不完整时返回io.ErrUnexpectedEOF
+func (b *BinlogReader) Read() (Record, error) {
+	op, err := b.r.ReadByte()
+	if err != nil {
+		return Record{}, err
+	}
+	r := Record{Op: Op(op)}
+	if r.Op < OpGet || r.Op > OpPurge || (r.Op == OpPurge && b.version == 1) {
+		return Record{}, fmt.Errorf("%w: unknown op %d", ErrBadBinlog, op)
+	}
+
+	delta, err := binary.ReadVarint(b.r)
+	if err != nil {
+		return Record{}, unexpected(err)
+	}
+	b.last += delta
+	r.Time = time.Unix(0, b.last)
+
+	var hash [8]byte
+	if _, err = io.ReadFull(b.r, hash[:]); err != nil {
+		return Record{}, unexpected(err)
+	}
+	r.Hash = binary.LittleEndian.Uint64(hash[:])
+
+	if r.Op == OpAdd {
+		w, err := binary.ReadUvarint(b.r)
+		if err != nil {
+			return Record{}, unexpected(err)
+		}
+		r.Weight = int64(w)
+	}
+	return r, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// HashBinlogKey 以seed计算key在binlog中的hash，与进程无关。
+// string与整数按内容计算，其他类型按%#v格式化后计算，包含指针的key在不同进程中的hash不同
+func HashBinlogKey[K comparable](seed uint64, key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return hashBinlogString(seed, k)
+	case int:
+		return mix(seed ^ uint64(k))
+	case int32:
+		return mix(seed ^ uint64(k))
+	case int64:
+		return mix(seed ^ uint64(k))
+	case uint:
+		return mix(seed ^ uint64(k))
+	case uint32:
+		return mix(seed ^ uint64(k))
+	case uint64:
+		return mix(seed ^ k)
+	default:
+		return hashBinlogString(seed, fmt.Sprintf("%#v", key))
+	}
+}
+
+// hashBinlogString FNV-1a，初始值混入seed，最后用mix打散
+func hashBinlogString(seed uint64, s string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64) ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime64
+	}
+	return mix(h)
+}
+
+// Recorder 把任意缓存的访问写入binlog，key以binlog头部的seed转换为hash
+type Recorder[K comparable] struct {
+	w    *BinlogWriter
+	seed uint64
+	now  func() time.Time
+}
+
+func NewRecorder[K comparable](w *BinlogWriter) *Recorder[K] {
+	return &Recorder[K]{
+		w:    w,
+		seed: w.Seed(),
+		now:  time.Now,
+	}
+}
+
+func (r *Recorder[K]) RecordGet(key K) {
+	r.record(OpGet, key, 0)
+}
+
+func (r *Recorder[K]) RecordAdd(key K, weight int64) {
+	r.record(OpAdd, key, weight)
+}
+
+func (r *Recorder[K]) RecordRemove(key K) {
+	r.record(OpRemove, key, 0)
+}
+
+func (r *Recorder[K]) RecordPurge() {
+	_ = r.w.Write(Record{Op: OpPurge, Time: r.now()})
+}
+
+// record 写入失败不影响缓存本身，错误由BinlogWriter.Err返回
+func (r *Recorder[K]) record(op Op, key K, weight int64) {
+	_ = r.w.Write(Record{Op: op, Time: r.now(), Hash: HashBinlogKey(r.seed, key), Weight: weight})
+}
+
+type recordingLRU[K comparable, V any] struct {
+	ihe_lru.LRU[K, V]
+	r       *Recorder[K]
+	weigher func(key K, value V) int64
+}
+
+// NewRecordingLRU 包装l，把Get、Add、Remove、RemoveOldest、Purge写入binlog。weigher用于记录add的重量，nil时每个元素重量为1
+func NewRecordingLRU[K comparable, V any](l ihe_lru.LRU[K, V], w *BinlogWriter, weigher func(key K, value V) int64) ihe_lru.LRU[K, V] {
+	return &recordingLRU[K, V]{
+		LRU:     l,
+		r:       NewRecorder[K](w),
+		weigher: weigher,
+	}
+}
+
+func (l *recordingLRU[K, V]) Get(key K) (V, bool) {
+	l.r.RecordGet(key)
+	return l.LRU.Get(key)
+}
+
+func (l *recordingLRU[K, V]) Add(key K, value V) (K, V, bool) {
+	l.r.RecordAdd(key, l.weigh(key, value))
+	return l.LRU.Add(key, value)
+}
+
+func (l *recordingLRU[K, V]) AddWithTTL(key K, value V, ttl time.Duration) (K, V, bool) {
+	l.r.RecordAdd(key, l.weigh(key, value))
+	return l.LRU.AddWithTTL(key, value, ttl)
+}
+
+func (l *recordingLRU[K, V]) Remove(key K) bool {
+	l.r.RecordRemove(key)
+	return l.LRU.Remove(key)
+}
+
+// RemoveOldest 记录为删除实际被删除的key，回放时不依赖回放目标自己的淘汰顺序
+func (l *recordingLRU[K, V]) RemoveOldest() (K, V, bool) {
+	key, value, ok := l.LRU.RemoveOldest()
+	if ok {
+		l.r.RecordRemove(key)
+	}
+	return key, value, ok
+}
+
+func (l *recordingLRU[K, V]) Purge() {
+	l.r.RecordPurge()
+	l.LRU.Purge()
+}
+
+func (l *recordingLRU[K, V]) weigh(key K, value V) int64 {
+	if l.weigher == nil {
+		return 1
+	}
+	return l.weigher(key, value)
+}
+
+type recordingCache[K comparable, V any] struct {
+	ihe_lru.Cache[K, V]
+	r       *Recorder[K]
+	weigher func(key K, value V) int64
+}
+
+// NewRecordingCache 同NewRecordingLRU，包装Cache，把Get、Set、Remove写入binlog。
+// W-TinyLFU经NewSyncCache包装后、各并发策略都可以这样记录
+func NewRecordingCache[K comparable, V any](c ihe_lru.Cache[K, V], w *BinlogWriter, weigher func(key K, value V) int64) ihe_lru.Cache[K, V] {
+	return &recordingCache[K, V]{
+		Cache:   c,
+		r:       NewRecorder[K](w),
+		weigher: weigher,
+	}
+}
+
+func (c *recordingCache[K, V]) Get(key K) (V, bool) {
+	c.r.RecordGet(key)
+	return c.Cache.Get(key)
+}
+
+func (c *recordingCache[K, V]) Set(key K, value V) {
+	weight := int64(1)
+	if c.weigher != nil {
+		weight = c.weigher(key, value)
+	}
+	c.r.RecordAdd(key, weight)
+	c.Cache.Set(key, value)
+}
+
+func (c *recordingCache[K, V]) Remove(key K) bool {
+	c.r.RecordRemove(key)
+	return c.Cache.Remove(key)
+}
+
+// ReplayTarget 回放binlog的缓存，key为记录中的hash。同时实现Purger时回放purge，否则忽略
+type ReplayTarget interface {
+	// Get 返回是否命中
+	Get(key uint64) bool
+	Add(key uint64, weight int64)
+	Remove(key uint64)
+}
+
+// Purger 可以清空的回放目标
+type Purger interface {
+	Purge()
+}
+
+// Replay 依次把binlog中的记录交给t，返回回放的记录数。不按记录的时间间隔等待
+func Replay(r io.Reader, t ReplayTarget) (int, error) {
+	br, err := NewBinlogReader(r)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		rec, err := br.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		switch rec.Op {
+		case OpGet:
+			t.Get(rec.Hash)
+		case OpAdd:
+			t.Add(rec.Hash, rec.Weight)
+		case OpRemove:
+			t.Remove(rec.Hash)
+		case OpPurge:
+			if p, ok := t.(Purger); ok {
+				p.Purge()
+			}
+		}
+		n++
+	}
+}
+
+// LRUTarget 让LRU[uint64, int64]作为回放目标，value为元素重量
+func LRUTarget(l ihe_lru.LRU[uint64, int64]) ReplayTarget {
+	return lruTarget{l}
+}
+
+type lruTarget struct {
+	l ihe_lru.LRU[uint64, int64]
+}
+
+func (t lruTarget) Get(key uint64) bool {
+	_, ok := t.l.Get(key)
+	return ok
+}
+
+func (t lruTarget) Add(key uint64, weight int64) {
+	t.l.Add(key, weight)
+}
+
+func (t lruTarget) Remove(key uint64) {
+	t.l.Remove(key)
+}
+
+func (t lruTarget) Purge() {
+	t.l.Purge()
+}
+
+// CacheTarget 让Cache[uint64, int64]作为回放目标，value为元素重量
+func CacheTarget(c ihe_lru.Cache[uint64, int64]) ReplayTarget {
+	return cacheTarget{c}
+}
+
+type cacheTarget struct {
+	c ihe_lru.Cache[uint64, int64]
+}
+
+func (t cacheTarget) Get(key uint64) bool {
+	_, ok := t.c.Get(key)
+	return ok
+}
+
+func (t cacheTarget) Add(key uint64, weight int64) {
+	t.c.Set(key, weight)
+}
+
+func (t cacheTarget) Remove(key uint64) {
+	t.c.Remove(key)
+}
@@ -0,0 +1,198 @@
+package tinylfu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"learn/ihe-lru"
+	"strconv"
+	"testing"
+)
+
+func TestBinlogRecordAndReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewBinlogWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := ihe_lru.NewGeneralLRU[string, string](2)
+	l := NewRecordingLRU(origin, w, func(key, value string) int64 {
+		return int64(len(value))
+	})
+	l.Get("a")
+	l.Add("a", "apple")
+	l.Add("b", "banana")
+	l.Get("a")
+	l.Add("c", "cherry")
+	l.Get("b")
+	l.Remove("a")
+	l.Get("a")
+	l.Add("d", "date")
+	if _, _, ok := l.RemoveOldest(); !ok {
+		t.Fatal("should remove oldest")
+	}
+	l.Purge()
+	l.Get("d")
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewBinlogReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	ops := []Op{OpGet, OpAdd, OpAdd, OpGet, OpAdd, OpGet, OpRemove, OpGet, OpAdd, OpRemove, OpPurge, OpGet}
+	if len(recs) != len(ops) {
+		t.Fatalf("should read %d records, got %d", len(ops), len(recs))
+	}
+	for i, rec := range recs {
+		if rec.Op != ops[i] {
+			t.Fatalf("record %d should be %v, got %v", i, ops[i], rec.Op)
+		}
+		if i > 0 && rec.Time.Before(recs[i-1].Time) {
+			t.Fatalf("record %d goes back in time", i)
+		}
+	}
+	if recs[0].Hash != recs[1].Hash || recs[1].Hash == recs[2].Hash || recs[2].Weight != 6 {
+		t.Fatalf("bad records %+v", recs[:3])
+	}
+	// RemoveOldest记录为删除实际被删除的c
+	if recs[9].Hash != recs[4].Hash {
+		t.Fatalf("remove oldest should record c, got %+v", recs[9])
+	}
+
+	replayed := ihe_lru.NewGeneralLRU[uint64, int64](2)
+	n, err := Replay(bytes.NewReader(buf.Bytes()), LRUTarget(replayed))
+	if err != nil || n != len(ops) {
+		t.Fatalf("replay %d records, err %v", n, err)
+	}
+	want, got := origin.Stats(), replayed.Stats()
+	if want.Hits != got.Hits || want.Misses != got.Misses || want.Size != got.Size {
+		t.Fatalf("replay should behave the same, want %+v got %+v", want, got)
+	}
+}
+
+// TestBinlogRecordCache 记录W-TinyLFU的访问，回放到同样大小的W-TinyLFU，命中与元素个数一致。
+// key少于容量，不涉及准入，结果与hash无关
+func TestBinlogRecordCache(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewBinlogWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := ihe_lru.NewSyncCache(NewCache[string, string](64))
+	c := NewRecordingCache(origin, w, nil)
+	keys := make([]string, 32)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		c.Get(keys[i])
+		c.Set(keys[i], keys[i])
+	}
+	for i, key := range keys {
+		c.Get(key)
+		if i%2 == 0 {
+			c.Remove(key)
+		}
+	}
+	for _, key := range keys {
+		c.Get(key)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := ihe_lru.NewSyncCache(NewCache[uint64, int64](64))
+	n, err := Replay(bytes.NewReader(buf.Bytes()), CacheTarget(replayed))
+	if err != nil || n != len(keys)*4+len(keys)/2 {
+		t.Fatalf("replay %d records, err %v", n, err)
+	}
+	want, got := origin.Stats(), replayed.Stats()
+	if want.Hits != got.Hits || want.Misses != got.Misses || want.Size != got.Size || got.Size != len(keys)/2 {
+		t.Fatalf("replay should behave the same, want %+v got %+v", want, got)
+	}
+}
+
+// TestBinlogSeed 同一seed下不同binlog中同一key的hash相同，与进程无关，可由HashBinlogKey算出
+func TestBinlogSeed(t *testing.T) {
+	record := func(seed uint64) (uint64, uint64) {
+		buf := &bytes.Buffer{}
+		w, err := NewBinlogWriterWithSeed(buf, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewRecorder[string](w).RecordGet("a")
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewBinlogReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := r.Seed()
+		if !ok || got != seed {
+			t.Fatalf("want seed %d, got %d %v", seed, got, ok)
+		}
+		rec, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.Hash, got
+	}
+	h1, seed := record(42)
+	h2, _ := record(42)
+	if h1 != h2 || h1 != HashBinlogKey(seed, "a") {
+		t.Fatalf("same seed should give the same hash, got %x %x", h1, h2)
+	}
+	if h3, _ := record(43); h3 == h1 {
+		t.Fatal("different seeds should give different hashes")
+	}
+	// 固定的期望值，hash算法变化会让之前的binlog无法比较
+	if h := HashBinlogKey(DefaultBinlogSeed, "a"); h != 0x2c0bdbf481420f8 {
+		t.Fatalf("hash of a changed, got %#x", h)
+	}
+	if h := HashBinlogKey(DefaultBinlogSeed, 1); h != 0x5692161d100b05e5 {
+		t.Fatalf("hash of 1 changed, got %#x", h)
+	}
+	if HashBinlogKey(1, 7) == HashBinlogKey(1, 8) || HashBinlogKey(1, "7") == HashBinlogKey(1, "8") {
+		t.Fatal("different keys should hash differently")
+	}
+}
+
+func TestBinlogBadInput(t *testing.T) {
+	if _, err := NewBinlogReader(bytes.NewReader([]byte("NOTALOG!"))); !errors.Is(err, ErrBadBinlog) {
+		t.Fatalf("should reject bad magic, got %v", err)
+	}
+
+	// 版本1没有seed，仍可读取
+	v1 := append([]byte(binlogMagic+"\x01"), byte(OpGet), 0, 1, 0, 0, 0, 0, 0, 0, 0)
+	r, err := NewBinlogReader(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Seed(); ok {
+		t.Fatal("version 1 should have no seed")
+	}
+	if rec, err := r.Read(); err != nil || rec.Op != OpGet || rec.Hash != 1 {
+		t.Fatalf("should read version 1 record, got %+v %v", rec, err)
+	}
+
+	buf := &bytes.Buffer{}
+	w, _ := NewBinlogWriter(buf)
+	w.Write(Record{Op: OpAdd, Hash: 1, Weight: 1})
+	w.Flush()
+	truncated := buf.Bytes()[:buf.Len()-3]
+	if _, err := Replay(bytes.NewReader(truncated), LRUTarget(ihe_lru.NewGeneralLRU[uint64, int64](1))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("should report truncated record, got %v", err)
+	}
+}
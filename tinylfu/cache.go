@@ -0,0 +1,536 @@
+package tinylfu
+
+import (
+	"container/heap"
+	"container/list"
+	"learn/ihe-lru"
+	"math"
+	"time"
+)
+
+// W-TinyLFU: 新元素先进入很小的window lru，从window溢出的元素作为候选，
+// 与main区(probation + protected组成的分段lru)将被驱逐的元素比较TinyLFU估计的访问频率，频率高者留下。
+// probation中的元素再次被访问后晋升到protected，protected溢出的元素降回probation。
+// 这样突发的新元素有window缓冲，而一次性扫描的大量元素很难挤掉main区的高频元素。
+// window占比由HillClimber根据命中率在线调整：偏重最近访问的负载window变大，偏重频率的负载window变小
+//
+// 和NewGeneralLRU一样并非线程安全
+
+type region uint8
+
+const (
+	windowRegion region = iota
+	probationRegion
+	protectedRegion
+)
+
+const (
+	// defaultWindowRatio window占总容量的初始比例
+	defaultWindowRatio = 0.01
+	// protectedPercent protected占main区的百分比
+	protectedPercent = 80
+)
+
+type entry[K comparable, V any] struct {
+	key    K
+	value  V
+	hash   uint64
+	weight int64
+	region region
+	elem   *list.Element
+	// writeDeadline 写入时确定的过期时间，expireAt 考虑访问后实际的过期时间。零值表示不过期
+	writeDeadline time.Time
+	expireAt      time.Time
+	// index 在过期堆中的位置，不在堆中为-1
+	index int
+}
+
+type cache[K comparable, V any] struct {
+	items     map[K]*entry[K, V]
+	window    *list.List
+	probation *list.List
+	protected *list.List
+
+	// size 元素个数上限，maximum 总重量上限，各区域按重量划分。小于等于0表示不限制
+	size            int
+	maximum         int64
+	windowMax       int64
+	protectedMax    int64
+	weight          int64
+	windowWeight    int64
+	protectedWeight int64
+
+	sketch      *TinyLFU
+	hasher      Hasher[K]
+	climber     *HillClimber
+	windowRatio float64
+
+	onEvict           func(key K, value V, reason ihe_lru.EvictReason)
+	expireAfterWrite  time.Duration
+	expireAfterAccess time.Duration
+	now               func() time.Time
+	expiry            expiryHeap[K, V]
+	weigher           func(key K, value V) int64
+	maxWeight         int64
+
+	stats ihe_lru.StatsCounter
+}
+
+// NewCache 容量为size个元素的W-TinyLFU缓存
+func NewCache[K comparable, V any](size int) ihe_lru.LRU[K, V] {
+	return NewCacheWithOptions[K, V](size, ihe_lru.Options[K, V]{})
+}
+
+// NewCacheWithOptions size为元素个数上限。设置了opts.MaxWeight时各区域按重量划分，size仍限制元素个数，小于等于0表示不限制
+func NewCacheWithOptions[K comparable, V any](size int, opts ihe_lru.Options[K, V]) ihe_lru.LRU[K, V] {
+	return newCache(size, opts)
+}
+
+func newCache[K comparable, V any](size int, opts ihe_lru.Options[K, V]) *cache[K, V] {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	c := &cache[K, V]{
+		items:     make(map[K]*entry[K, V]),
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		hasher:    NewHasher[K](),
+
+		windowRatio: defaultWindowRatio,
+
+		onEvict:           opts.OnEvict,
+		expireAfterWrite:  opts.ExpireAfterWrite,
+		expireAfterAccess: opts.ExpireAfterAccess,
+		now:               now,
+		weigher:           opts.Weigher,
+		maxWeight:         opts.MaxWeight,
+	}
+	c.setSize(size)
+	c.sketch = NewTinyLFU(c.sketchCapacity(), 0)
+	c.climber = NewHillClimber(c.sketch.SampleSize())
+	return c
+}
+
+// setSize 更新上限并按比例重新划分各区域
+func (c *cache[K, V]) setSize(size int) {
+	c.size = size
+	c.maximum = c.maxWeight
+	if c.maximum <= 0 {
+		c.maximum = int64(size)
+	}
+	c.setWindowMax(int64(float64(c.maximum) * c.windowRatio))
+}
+
+// setWindowMax 调整window大小，main区及其中的protected随之调整
+func (c *cache[K, V]) setWindowMax(windowMax int64) {
+	// 不限制容量时不需要划分区域，元素全部留在window
+	if c.maximum <= 0 {
+		c.windowMax, c.protectedMax = math.MaxInt64, math.MaxInt64
+		return
+	}
+	if windowMax < 1 {
+		windowMax = 1
+	}
+	if windowMax > c.maximum {
+		windowMax = c.maximum
+	}
+	c.windowMax = windowMax
+	c.protectedMax = (c.maximum - windowMax) * protectedPercent / 100
+}
+
+func (c *cache[K, V]) sketchCapacity() int {
+	const maxSketchCapacity = 1 << 24
+	n := c.maximum
+	if c.size > 0 && (n <= 0 || int64(c.size) < n) {
+		n = int64(c.size)
+	}
+	if n <= 0 || n > maxSketchCapacity {
+		n = maxSketchCapacity
+	}
+	return int(n)
+}
+
+func (c *cache[K, V]) Get(key K) (V, bool) {
+	c.removeExpired()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.sketch.Increment(c.hasher.Hash(key))
+		c.stats.RecordMiss()
+		c.climb(false)
+		var zero V
+		return zero, false
+	}
+	c.stats.RecordHit()
+	c.onAccess(e)
+	c.climb(true)
+	return e.value, true
+}
+
+// climb 每个采样周期结束时按HillClimber的结果调整window大小
+func (c *cache[K, V]) climb(hit bool) {
+	adjust, ok := c.climber.Record(hit)
+	if !ok || c.maximum <= 0 {
+		return
+	}
+	c.windowRatio = ClampWindowRatio(c.windowRatio + adjust)
+	c.setWindowMax(int64(float64(c.maximum) * c.windowRatio))
+	// window缩小时溢出的元素移到main区；变大时由之后的新元素填满，main区相应驱逐
+	c.demoteProtected()
+	c.evictEntries()
+}
+
+// onAccess 记录访问频率，并按所在区域调整位置，顺延访问过期时间
+func (c *cache[K, V]) onAccess(e *entry[K, V]) {
+	c.sketch.Increment(e.hash)
+	if c.expireAfterAccess > 0 {
+		c.setExpireAt(e, deadline(e.writeDeadline, c.now().Add(c.expireAfterAccess)))
+	}
+
+	switch e.region {
+	case windowRegion:
+		c.window.MoveToFront(e.elem)
+	case probationRegion:
+		// 再次访问，晋升到protected
+		c.probation.Remove(e.elem)
+		c.pushProtected(e)
+		c.demoteProtected()
+	case protectedRegion:
+		c.protected.MoveToFront(e.elem)
+	}
+}
+
+func (c *cache[K, V]) Add(key K, value V) (evictedKey K, evictedValue V, evicted bool) {
+	return c.AddWithTTL(key, value, c.expireAfterWrite)
+}
+
+// AddWithTTL 添加或更新元素，元素在ttl后过期，ttl为0表示不过期。返回第一个被驱逐的元素
+func (c *cache[K, V]) AddWithTTL(key K, value V, ttl time.Duration) (evictedKey K, evictedValue V, evicted bool) {
+	c.removeExpired()
+
+	var writeDeadline, expireAt time.Time
+	if ttl > 0 || c.expireAfterAccess > 0 {
+		now := c.now()
+		if ttl > 0 {
+			writeDeadline = now.Add(ttl)
+		}
+		expireAt = writeDeadline
+		if c.expireAfterAccess > 0 {
+			expireAt = deadline(writeDeadline, now.Add(c.expireAfterAccess))
+		}
+	}
+
+	// 单个元素就超过重量限制，不可能放入缓存，视为立即被驱逐
+	w := c.weigh(key, value)
+	if c.maxWeight > 0 && w > c.maxWeight {
+		if e, ok := c.items[key]; ok {
+			c.removeEntry(e, ihe_lru.EvictByReplace)
+		}
+		c.notifyEvict(key, value, ihe_lru.EvictByCapacity)
+		return key, value, true
+	}
+
+	// 1. 已存在则更新value，视为一次访问
+	if e, ok := c.items[key]; ok {
+		old := e.value
+		e.value = value
+		e.writeDeadline = writeDeadline
+		c.setExpireAt(e, expireAt)
+		c.addWeight(e, w-e.weight)
+		e.weight = w
+		c.onAccess(e)
+		c.notifyEvict(key, old, ihe_lru.EvictByReplace)
+		return c.evictEntries()
+	}
+
+	// 2. 新元素放入window
+	e := &entry[K, V]{
+		key:           key,
+		value:         value,
+		hash:          c.hasher.Hash(key),
+		weight:        w,
+		writeDeadline: writeDeadline,
+		index:         -1,
+	}
+	c.sketch.Increment(e.hash)
+	c.setExpireAt(e, expireAt)
+	c.items[key] = e
+	e.region = windowRegion
+	e.elem = c.window.PushFront(e)
+	c.weight += w
+	c.windowWeight += w
+
+	// 3. window溢出的元素与main区竞争
+	return c.evictEntries()
+}
+
+// evictEntries 先把window溢出的元素移到main区作为候选，再从main区驱逐到满足上限。返回第一个被驱逐的元素
+func (c *cache[K, V]) evictEntries() (evictedKey K, evictedValue V, evicted bool) {
+	record := func(e *entry[K, V]) {
+		c.removeEntry(e, ihe_lru.EvictByCapacity)
+		if !evicted {
+			evictedKey, evictedValue, evicted = e.key, e.value, true
+		}
+	}
+
+	// 1. window溢出的元素与main区的受害者比较频率，输者被驱逐
+	for c.windowWeight > c.windowMax && c.window.Len() > 1 {
+		candidate := c.window.Back().Value.(*entry[K, V])
+		c.window.Remove(candidate.elem)
+		c.windowWeight -= candidate.weight
+		candidate.region = probationRegion
+		candidate.elem = c.probation.PushFront(candidate)
+
+		for c.weight > c.maximum {
+			victim := c.mainVictim(candidate)
+			if victim == nil {
+				record(candidate)
+				break
+			}
+			if c.admit(candidate, victim) {
+				record(victim)
+				continue
+			}
+			record(candidate)
+			break
+		}
+	}
+
+	// 2. window放不下时仍超出上限(比如window比main还大)，或超出个数上限，按oldest顺序驱逐
+	for c.overflow() {
+		victim := c.oldest()
+		if victim == nil {
+			break
+		}
+		record(victim)
+	}
+	return
+}
+
+func (c *cache[K, V]) overflow() bool {
+	return (c.maximum > 0 && c.weight > c.maximum) || (c.size > 0 && len(c.items) > c.size)
+}
+
+// admit 候选元素的频率高于受害者时才准入
+func (c *cache[K, V]) admit(candidate, victim *entry[K, V]) bool {
+	return c.sketch.Estimate(candidate.hash) > c.sketch.Estimate(victim.hash)
+}
+
+// mainVictim main区下一个被驱逐的元素：probation末尾，其次protected末尾。不会选中候选元素本身
+func (c *cache[K, V]) mainVictim(candidate *entry[K, V]) *entry[K, V] {
+	for _, l := range []*list.List{c.probation, c.protected} {
+		for b := l.Back(); b != nil; b = b.Prev() {
+			if e := b.Value.(*entry[K, V]); e != candidate {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// oldest 下一个被驱逐的元素：probation末尾，其次protected、window末尾
+func (c *cache[K, V]) oldest() *entry[K, V] {
+	for _, l := range []*list.List{c.probation, c.protected, c.window} {
+		if b := l.Back(); b != nil {
+			return b.Value.(*entry[K, V])
+		}
+	}
+	return nil
+}
+
+func (c *cache[K, V]) pushProtected(e *entry[K, V]) {
+	e.region = protectedRegion
+	e.elem = c.protected.PushFront(e)
+	c.protectedWeight += e.weight
+}
+
+// demoteProtected protected溢出的元素降回probation
+func (c *cache[K, V]) demoteProtected() {
+	for c.protectedWeight > c.protectedMax && c.protected.Len() > 1 {
+		e := c.protected.Back().Value.(*entry[K, V])
+		c.protected.Remove(e.elem)
+		c.protectedWeight -= e.weight
+		e.region = probationRegion
+		e.elem = c.probation.PushFront(e)
+	}
+}
+
+// addWeight 元素重量变化时维护所在区域的重量
+func (c *cache[K, V]) addWeight(e *entry[K, V], delta int64) {
+	c.weight += delta
+	switch e.region {
+	case windowRegion:
+		c.windowWeight += delta
+	case protectedRegion:
+		c.protectedWeight += delta
+	}
+}
+
+func (c *cache[K, V]) weigh(key K, value V) int64 {
+	if c.weigher == nil {
+		return 1
+	}
+	return c.weigher(key, value)
+}
+
+func (c *cache[K, V]) listOf(r region) *list.List {
+	switch r {
+	case windowRegion:
+		return c.window
+	case probationRegion:
+		return c.probation
+	default:
+		return c.protected
+	}
+}
+
+func (c *cache[K, V]) Remove(key K) bool {
+	c.removeExpired()
+	e, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.removeEntry(e, ihe_lru.EvictByRemove)
+	return true
+}
+
+func (c *cache[K, V]) Peek(key K) (V, bool) {
+	c.removeExpired()
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *cache[K, V]) Contains(key K) bool {
+	c.removeExpired()
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *cache[K, V]) Len() int {
+	c.removeExpired()
+	return len(c.items)
+}
+
+// Keys 依次返回window、protected、probation中的key，各区域内最近使用的在前
+func (c *cache[K, V]) Keys() []K {
+	c.removeExpired()
+	keys := make([]K, 0, len(c.items))
+	for _, l := range []*list.List{c.window, c.protected, c.probation} {
+		for e := l.Front(); e != nil; e = e.Next() {
+			keys = append(keys, e.Value.(*entry[K, V]).key)
+		}
+	}
+	return keys
+}
+
+func (c *cache[K, V]) Purge() {
+	c.removeExpired()
+	for _, l := range []*list.List{c.probation, c.protected, c.window} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			i := e.Value.(*entry[K, V])
+			c.notifyEvict(i.key, i.value, ihe_lru.EvictByRemove)
+		}
+		l.Init()
+	}
+	c.items = make(map[K]*entry[K, V])
+	c.expiry = nil
+	c.weight, c.windowWeight, c.protectedWeight = 0, 0, 0
+}
+
+// RemoveOldest 删除下一个将被驱逐的元素
+func (c *cache[K, V]) RemoveOldest() (K, V, bool) {
+	c.removeExpired()
+	e := c.oldest()
+	if e == nil {
+		var (
+			zk K
+			zv V
+		)
+		return zk, zv, false
+	}
+	c.removeEntry(e, ihe_lru.EvictByRemove)
+	return e.key, e.value, true
+}
+
+// GetOldest 返回下一个将被驱逐的元素
+func (c *cache[K, V]) GetOldest() (K, V, bool) {
+	c.removeExpired()
+	e := c.oldest()
+	if e == nil {
+		var (
+			zk K
+			zv V
+		)
+		return zk, zv, false
+	}
+	return e.key, e.value, true
+}
+
+// Resize 调整元素个数上限。未设置MaxWeight时各区域随之按比例调整
+func (c *cache[K, V]) Resize(size int) (evicted int) {
+	c.removeExpired()
+	c.setSize(size)
+	c.demoteProtected()
+	for c.overflow() {
+		victim := c.oldest()
+		if victim == nil {
+			break
+		}
+		c.removeEntry(victim, ihe_lru.EvictByCapacity)
+		evicted++
+	}
+	return
+}
+
+func (c *cache[K, V]) Stats() ihe_lru.Stats {
+	c.removeExpired()
+	return c.stats.Snapshot(len(c.items), c.weight)
+}
+
+func (c *cache[K, V]) removeEntry(e *entry[K, V], reason ihe_lru.EvictReason) {
+	c.listOf(e.region).Remove(e.elem)
+	c.addWeight(e, -e.weight)
+	delete(c.items, e.key)
+	if e.index >= 0 {
+		heap.Remove(&c.expiry, e.index)
+	}
+	c.notifyEvict(e.key, e.value, reason)
+}
+
+// setExpireAt 更新元素过期时间，并维护其在过期堆中的位置
+func (c *cache[K, V]) setExpireAt(e *entry[K, V], expireAt time.Time) {
+	e.expireAt = expireAt
+	switch {
+	case expireAt.IsZero() && e.index >= 0:
+		heap.Remove(&c.expiry, e.index)
+	case expireAt.IsZero():
+	case e.index >= 0:
+		heap.Fix(&c.expiry, e.index)
+	default:
+		heap.Push(&c.expiry, e)
+	}
+}
+
+// removeExpired 同NewGeneralLRU，每次访问时从过期堆顶开始清理
+func (c *cache[K, V]) removeExpired() {
+	if len(c.expiry) == 0 {
+		return
+	}
+	now := c.now()
+	for len(c.expiry) > 0 && !c.expiry[0].expireAt.After(now) {
+		c.removeEntry(c.expiry[0], ihe_lru.EvictByExpire)
+	}
+}
+
+func (c *cache[K, V]) notifyEvict(key K, value V, reason ihe_lru.EvictReason) {
+	c.stats.RecordEviction(reason)
+	if c.onEvict != nil {
+		c.onEvict(key, value, reason)
+	}
+}
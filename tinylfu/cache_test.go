@@ -0,0 +1,187 @@
+package tinylfu
+
+import (
+	"learn/ihe-lru"
+	"learn/ihe-lru/workload"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheBasicUse(t *testing.T) {
+	var evicted []string
+	c := NewCacheWithOptions[string, int](10, ihe_lru.Options[string, int]{
+		OnEvict: func(key string, value int, reason ihe_lru.EvictReason) {
+			if reason == ihe_lru.EvictByCapacity {
+				evicted = append(evicted, key)
+			}
+		},
+	})
+	for i := 0; i < 10; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+	if c.Len() != 10 {
+		t.Fatalf("bad len %d", c.Len())
+	}
+	if v, ok := c.Get("3"); !ok || v != 3 {
+		t.Fatalf("should get 3, got %v %v", v, ok)
+	}
+	if v, ok := c.Peek("4"); !ok || v != 4 {
+		t.Fatalf("should peek 4, got %v %v", v, ok)
+	}
+
+	_, _, ok := c.Add("10", 10)
+	if !ok || c.Len() != 10 || len(evicted) != 1 {
+		t.Fatalf("should evict one, len %d evicted %v", c.Len(), evicted)
+	}
+	if !c.Contains("3") {
+		t.Fatal("accessed 3 should survive")
+	}
+
+	if !c.Remove("3") || c.Contains("3") || c.Remove("3") {
+		t.Fatal("remove 3 failed")
+	}
+	if len(c.Keys()) != c.Len() {
+		t.Fatalf("keys %v do not match len %d", c.Keys(), c.Len())
+	}
+	k, _, ok := c.GetOldest()
+	rk, _, rok := c.RemoveOldest()
+	if !ok || !rok || k != rk || c.Contains(k) {
+		t.Fatalf("oldest %v should be removed, got %v", k, rk)
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Fatalf("purge should clear, got %d", c.Len())
+	}
+}
+
+func TestCacheScanResistance(t *testing.T) {
+	size := 100
+	hot := 50
+	c := NewCache[int, int](size)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < hot; i++ {
+			if _, ok := c.Get(i); !ok {
+				c.Add(i, i)
+			}
+		}
+	}
+
+	// 一次性扫描大量冷数据
+	for i := 1000; i < 1000+size*10; i++ {
+		if _, ok := c.Get(i); !ok {
+			c.Add(i, i)
+		}
+	}
+
+	kept := 0
+	for i := 0; i < hot; i++ {
+		if c.Contains(i) {
+			kept++
+		}
+	}
+	if kept < hot*9/10 {
+		t.Fatalf("hot keys should survive scan, kept %d/%d", kept, hot)
+	}
+	if c.Len() > size {
+		t.Fatalf("len %d exceeds size", c.Len())
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Now()
+	c := NewCacheWithOptions[string, string](10, ihe_lru.Options[string, string]{
+		ExpireAfterWrite: time.Minute,
+		Now:              func() time.Time { return now },
+	})
+	c.Add("hello", "world")
+	c.AddWithTTL("token", "abc", time.Second)
+
+	now = now.Add(2 * time.Second)
+	if c.Contains("token") || !c.Contains("hello") {
+		t.Fatal("token should expire before hello")
+	}
+	now = now.Add(time.Minute)
+	if c.Len() != 0 {
+		t.Fatalf("all should expire, got %v", c.Keys())
+	}
+	if s := c.Stats(); s.Evictions[ihe_lru.EvictByExpire] != 2 {
+		t.Fatalf("bad evictions %v", s.Evictions)
+	}
+}
+
+// TestCacheExpiredReclaimed 过期元素不会被Remove、Resize当作删除或容量驱逐
+func TestCacheExpiredReclaimed(t *testing.T) {
+	now := time.Now()
+	c := NewCacheWithOptions[string, string](10, ihe_lru.Options[string, string]{
+		ExpireAfterWrite: time.Minute,
+		Now:              func() time.Time { return now },
+	})
+	for i := 0; i < 4; i++ {
+		c.Add(strconv.Itoa(i), "v")
+	}
+	now = now.Add(2 * time.Minute)
+	if c.Remove("0") {
+		t.Fatal("expired 0 should not be removed")
+	}
+	if evicted := c.Resize(2); evicted != 0 {
+		t.Fatalf("expired entries should make room, got %d capacity evictions", evicted)
+	}
+	if s := c.Stats(); s.Evictions[ihe_lru.EvictByExpire] != 4 || s.Evictions[ihe_lru.EvictByRemove] != 0 {
+		t.Fatalf("bad evictions %v", s.Evictions)
+	}
+}
+
+func TestCacheWeight(t *testing.T) {
+	c := NewCacheWithOptions[string, []byte](0, ihe_lru.Options[string, []byte]{
+		Weigher: func(key string, value []byte) int64 {
+			return int64(len(value))
+		},
+		MaxWeight: 100,
+	})
+	for i := 0; i < 10; i++ {
+		c.Add(strconv.Itoa(i), make([]byte, 30))
+		if s := c.Stats(); s.Weight > 100 {
+			t.Fatalf("weight %d exceeds max weight", s.Weight)
+		}
+	}
+
+	k, _, ok := c.Add("huge", make([]byte, 101))
+	if !ok || k != "huge" || c.Contains("huge") {
+		t.Fatal("huge should be rejected")
+	}
+}
+
+func TestCacheResize(t *testing.T) {
+	c := NewCache[int, int](100)
+	for i := 0; i < 100; i++ {
+		c.Add(i, i)
+	}
+	if evicted := c.Resize(50); evicted != 50 || c.Len() != 50 {
+		t.Fatalf("should evict 50, got %d len %d", evicted, c.Len())
+	}
+	if evicted := c.Resize(200); evicted != 0 {
+		t.Fatalf("grow should not evict, got %d", evicted)
+	}
+	for i := 100; i < 250; i++ {
+		c.Add(i, i)
+	}
+	if c.Len() != 200 {
+		t.Fatalf("should hold 200, got %d", c.Len())
+	}
+}
+
+func BenchmarkCacheZipf(b *testing.B) {
+	c := NewCache[string, int](1000)
+	keys := workload.Keys(workload.NewZipf(1, 10000, 0.99), 1<<16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := keys[i&(len(keys)-1)]
+		if _, ok := c.Get(k); !ok {
+			c.Add(k, i)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(c.Stats().HitRatio()*100, "hit%")
+}
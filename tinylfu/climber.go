@@ -0,0 +1,105 @@
+package tinylfu
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// HillClimber 在线调整window占总容量的比例
+// 每sampleSize次访问为一个周期，命中率比上一周期高则沿原方向继续调整，低则反向；
+// 步长每周期衰减，命中率突变(比如访问模式切换)时恢复初始步长重新寻找
+// 可并发Record
+type HillClimber struct {
+	sampleSize uint64
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+
+	mu          sync.Mutex
+	prevHitRate float64
+	step        float64
+}
+
+const (
+	// initialStep 初始步长，占总容量的比例
+	initialStep = 0.0625
+	minStep     = 0.005
+	stepDecay   = 0.98
+	// restartThreshold 命中率变化超过该值时恢复初始步长
+	restartThreshold = 0.05
+
+	// minWindowRatio、maxWindowRatio window占比的调整范围
+	minWindowRatio = 0.01
+	maxWindowRatio = 0.8
+)
+
+// NewHillClimber sampleSize为每个周期的访问次数，通常为容量的10倍
+func NewHillClimber(sampleSize int) *HillClimber {
+	if sampleSize < 1 {
+		sampleSize = 1
+	}
+	return &HillClimber{
+		sampleSize: uint64(sampleSize),
+		step:       initialStep,
+	}
+}
+
+// Record 记录一次访问是否命中。周期结束时返回window占比的调整量(可正可负)以及true
+func (h *HillClimber) Record(hit bool) (float64, bool) {
+	var n uint64
+	if hit {
+		n = h.hits.Add(1) + h.misses.Load()
+	} else {
+		n = h.misses.Add(1) + h.hits.Load()
+	}
+	if n < h.sampleSize {
+		return 0, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hits, misses := h.hits.Load(), h.misses.Load()
+	// 其他goroutine已经结束了这个周期
+	if hits+misses < h.sampleSize {
+		return 0, false
+	}
+	h.hits.Add(-hits)
+	h.misses.Add(-misses)
+	return h.climb(float64(hits) / float64(hits+misses)), true
+}
+
+// climb 根据本周期命中率计算调整量
+func (h *HillClimber) climb(hitRate float64) float64 {
+	delta := hitRate - h.prevHitRate
+	h.prevHitRate = hitRate
+	if delta < 0 {
+		h.step = -h.step
+	}
+	adjust := h.step
+
+	if delta > restartThreshold || delta < -restartThreshold {
+		if h.step > 0 {
+			h.step = initialStep
+		} else {
+			h.step = -initialStep
+		}
+	} else {
+		h.step *= stepDecay
+		if h.step > 0 && h.step < minStep {
+			h.step = minStep
+		} else if h.step < 0 && h.step > -minStep {
+			h.step = -minStep
+		}
+	}
+	return adjust
+}
+
+// ClampWindowRatio 将window占比限制在可调整范围内
+func ClampWindowRatio(ratio float64) float64 {
+	if ratio < minWindowRatio {
+		return minWindowRatio
+	}
+	if ratio > maxWindowRatio {
+		return maxWindowRatio
+	}
+	return ratio
+}
@@ -0,0 +1,69 @@
+package tinylfu
+
+import (
+	"learn/ihe-lru"
+	"testing"
+)
+
+func TestHillClimber(t *testing.T) {
+	h := NewHillClimber(10)
+	record := func(hits int) float64 {
+		var adjust float64
+		var ok bool
+		for i := 0; i < 10; i++ {
+			adjust, ok = h.Record(i < hits)
+		}
+		if !ok {
+			t.Fatal("should adjust at the end of sample")
+		}
+		return adjust
+	}
+
+	if adjust := record(5); adjust != initialStep {
+		t.Fatalf("first sample should step forward, got %v", adjust)
+	}
+	if adjust := record(7); adjust <= 0 {
+		t.Fatalf("hit rate improved, should keep direction, got %v", adjust)
+	}
+	if adjust := record(2); adjust >= 0 {
+		t.Fatalf("hit rate dropped, should reverse, got %v", adjust)
+	}
+
+	// 命中率稳定时步长衰减
+	prev := record(2)
+	next := record(2)
+	if next < -initialStep || next > initialStep || abs(next) >= abs(prev) {
+		t.Fatalf("step should decay, prev %v next %v", prev, next)
+	}
+
+	if _, ok := h.Record(true); ok {
+		t.Fatal("should not adjust in the middle of sample")
+	}
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func TestCacheAdaptiveWindow(t *testing.T) {
+	size := 100
+	c := newCache[int, int](size, ihe_lru.Options[int, int]{})
+
+	// 只有最近访问有效的负载: 不断访问新key，紧接着再访问一次
+	for i := 0; i < size*500; i++ {
+		for _, k := range []int{i, i - 1} {
+			if _, ok := c.Get(k); !ok {
+				c.Add(k, k)
+			}
+		}
+	}
+	if c.windowRatio <= defaultWindowRatio {
+		t.Fatalf("recency workload should grow window, ratio %v", c.windowRatio)
+	}
+	if c.Len() > size || c.windowWeight > c.windowMax+1 {
+		t.Fatalf("bad len %d window %d/%d", c.Len(), c.windowWeight, c.windowMax)
+	}
+}
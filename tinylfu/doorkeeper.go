@@ -1 +1,78 @@
 package tinylfu
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Doorkeeper 布隆过滤器。只出现一次的key停留在这里，第二次出现才进入sketch计数，
+// 这样大量只访问一次的key不会挤占sketch的计数。可并发Put、Contains
+type Doorkeeper struct {
+	bits []uint64
+	mask uint64
+	k    int
+}
+
+// NewDoorkeeper expectedInsertions为一个重置周期内期望放入的key个数，fpp为期望的误判率
+func NewDoorkeeper(expectedInsertions int, fpp float64) *Doorkeeper {
+	if expectedInsertions < 1 {
+		expectedInsertions = 1
+	}
+	if fpp <= 0 || fpp >= 1 {
+		fpp = defaultFalsePositiveRate
+	}
+	// m = -n*ln(p)/(ln2)^2, k = m/n*ln2
+	m := -float64(expectedInsertions) * math.Log(fpp) / (math.Ln2 * math.Ln2)
+	words := nextPowerOfTwo(uint64(math.Ceil(m / 64)))
+	k := int(math.Round(m / float64(expectedInsertions) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Doorkeeper{
+		bits: make([]uint64, words),
+		mask: words*64 - 1,
+		k:    k,
+	}
+}
+
+// Put 放入h，返回放入之前是否已经存在
+func (d *Doorkeeper) Put(h uint64) bool {
+	exists := true
+	h1, h2 := d.split(h)
+	for i := 0; i < d.k; i++ {
+		bit := (h1 + uint64(i)*h2) & d.mask
+		word, b := bit/64, uint64(1)<<(bit%64)
+		if atomic.LoadUint64(&d.bits[word])&b != 0 {
+			continue
+		}
+		exists = false
+		atomic.OrUint64(&d.bits[word], b)
+	}
+	return exists
+}
+
+// Contains h是否可能已经放入过
+func (d *Doorkeeper) Contains(h uint64) bool {
+	h1, h2 := d.split(h)
+	for i := 0; i < d.k; i++ {
+		bit := (h1 + uint64(i)*h2) & d.mask
+		if atomic.LoadUint64(&d.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset 清空全部bit
+func (d *Doorkeeper) Reset() {
+	for i := range d.bits {
+		atomic.StoreUint64(&d.bits[i], 0)
+	}
+}
+
+// split 双重hash，用两个hash的线性组合模拟k个hash函数
+func (d *Doorkeeper) split(h uint64) (uint64, uint64) {
+	x := mix(h)
+	// h2为奇数，保证线性组合不会退化到同一个bit
+	return x, (x >> 32) | 1
+}
@@ -0,0 +1,33 @@
+package tinylfu
+
+import "testing"
+
+func TestDoorkeeper(t *testing.T) {
+	d := NewDoorkeeper(1000, 0.01)
+	h := NewHasher[int]()
+	for i := 0; i < 1000; i++ {
+		if d.Put(h.Hash(i)) && i < 10 {
+			t.Fatalf("%d should not exist before put", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !d.Contains(h.Hash(i)) {
+			t.Fatalf("%d should exist", i)
+		}
+	}
+
+	falsePositive := 0
+	for i := 1000; i < 11000; i++ {
+		if d.Contains(h.Hash(i)) {
+			falsePositive++
+		}
+	}
+	if falsePositive > 300 {
+		t.Fatalf("false positive rate too high: %d/10000", falsePositive)
+	}
+
+	d.Reset()
+	if d.Contains(h.Hash(1)) {
+		t.Fatal("reset should clear all")
+	}
+}
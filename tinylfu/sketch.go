@@ -0,0 +1,116 @@
+package tinylfu
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// CountMinSketch 4-bit计数的count-min sketch，用于估计key的访问频率
+// 每个uint64存16个计数，计数达到15后不再增长。每个key在depth个位置计数，估计时取最小值
+// 计数通过CAS原子修改，可并发Increment、Estimate；Reset时所有计数减半，让过去的访问逐渐失去影响
+type CountMinSketch struct {
+	table []uint64
+	mask  uint64
+}
+
+const (
+	sketchDepth = 4
+	// 每个计数4bit，一个uint64有16个
+	counterBits    = 4
+	counterMax     = 1<<counterBits - 1
+	countersPerRow = 64 / counterBits
+	// resetMask 右移一位后去掉从高位计数移进来的bit
+	resetMask = 0x7777777777777777
+)
+
+var sketchSeeds = [sketchDepth]uint64{
+	0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325,
+}
+
+// NewCountMinSketch capacity为期望区分的key个数。与Caffeine一样每个key一个uint64，向上取整到2的幂，
+// 每个key有16个计数，sketchDepth行中每行平均每个key至少有一个计数，hash冲突造成的高估很少
+func NewCountMinSketch(capacity int) *CountMinSketch {
+	if capacity < 1 {
+		capacity = 1
+	}
+	n := nextPowerOfTwo(uint64(capacity))
+	return &CountMinSketch{
+		table: make([]uint64, n),
+		mask:  n - 1,
+	}
+}
+
+// Increment 将h对应的计数加1，返回是否有计数真正增加(全部到达上限时不再增加)
+func (s *CountMinSketch) Increment(h uint64) bool {
+	added := false
+	for i := 0; i < sketchDepth; i++ {
+		idx, shift := s.locate(h, i)
+		for {
+			w := atomic.LoadUint64(&s.table[idx])
+			if (w>>shift)&counterMax == counterMax {
+				break
+			}
+			if atomic.CompareAndSwapUint64(&s.table[idx], w, w+1<<shift) {
+				added = true
+				break
+			}
+		}
+	}
+	return added
+}
+
+// Estimate 返回h的估计访问次数，最大为15
+func (s *CountMinSketch) Estimate(h uint64) uint8 {
+	min := uint8(counterMax)
+	for i := 0; i < sketchDepth; i++ {
+		idx, shift := s.locate(h, i)
+		if c := uint8(atomic.LoadUint64(&s.table[idx]) >> shift & counterMax); c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// Reset 所有计数减半
+func (s *CountMinSketch) Reset() {
+	for i := range s.table {
+		for {
+			w := atomic.LoadUint64(&s.table[i])
+			if atomic.CompareAndSwapUint64(&s.table[i], w, (w>>1)&resetMask) {
+				break
+			}
+		}
+	}
+}
+
+// Clear 清空所有计数
+func (s *CountMinSketch) Clear() {
+	for i := range s.table {
+		atomic.StoreUint64(&s.table[i], 0)
+	}
+}
+
+// locate 第i行计数所在的word下标以及在word中的偏移
+func (s *CountMinSketch) locate(h uint64, i int) (idx uint64, shift uint64) {
+	x := mix(h + sketchSeeds[i])
+	idx = x & s.mask
+	shift = (x >> 32) % countersPerRow * counterBits
+	return
+}
+
+// mix splitmix64的finalizer，让相近的hash分散开
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func nextPowerOfTwo(x uint64) uint64 {
+	if x <= 1 {
+		return 1
+	}
+	return 1 << bits.Len64(x-1)
+}
@@ -0,0 +1,112 @@
+package tinylfu
+
+import (
+	"learn/ihe-lru/workload"
+	"sync"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	s := NewCountMinSketch(1024)
+	h := NewHasher[string]()
+	for i := 0; i < 5; i++ {
+		s.Increment(h.Hash("hot"))
+	}
+	s.Increment(h.Hash("cold"))
+
+	if n := s.Estimate(h.Hash("hot")); n != 5 {
+		t.Fatalf("hot should be 5, got %d", n)
+	}
+	if n := s.Estimate(h.Hash("cold")); n != 1 {
+		t.Fatalf("cold should be 1, got %d", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Increment(h.Hash("hot"))
+	}
+	if n := s.Estimate(h.Hash("hot")); n != counterMax {
+		t.Fatalf("counter should saturate at %d, got %d", counterMax, n)
+	}
+
+	s.Reset()
+	if n := s.Estimate(h.Hash("hot")); n != counterMax/2 {
+		t.Fatalf("reset should halve, got %d", n)
+	}
+	if n := s.Estimate(h.Hash("cold")); n != 0 {
+		t.Fatalf("cold should be 0 after reset, got %d", n)
+	}
+}
+
+// TestCountMinSketchError Zipf分布的访问，key个数与容量相同，估计值不低于真实次数，高估的key很少
+func TestCountMinSketchError(t *testing.T) {
+	n := 1000
+	s := NewCountMinSketch(n)
+	h := NewHasher[string]()
+	counts := make(map[string]int)
+	for _, key := range workload.Keys(workload.NewZipf(1, uint64(n), 0.99), n*10) {
+		counts[key]++
+		s.Increment(h.Hash(key))
+	}
+	var over, errSum int
+	for key, c := range counts {
+		want := min(c, counterMax)
+		got := int(s.Estimate(h.Hash(key)))
+		if got < want {
+			t.Fatalf("%s: estimate %d below count %d", key, got, want)
+		}
+		if got > want {
+			over++
+			errSum += got - want
+		}
+	}
+	t.Logf("%d keys, %d overestimated, mean error %.4f", len(counts), over, float64(errSum)/float64(len(counts)))
+	// 每行一个key一个计数时约八成的key被高估，平均高估4以上
+	if over*50 > len(counts) || errSum*20 > len(counts) {
+		t.Fatalf("want at most 2%% keys overestimated and mean error below 0.05, got %d of %d keys, total error %d",
+			over, len(counts), errSum)
+	}
+}
+
+func TestCountMinSketchConcurrent(t *testing.T) {
+	s := NewCountMinSketch(1024)
+	h := NewHasher[int]()
+	wg := &sync.WaitGroup{}
+	wg.Add(10)
+	for g := 0; g < 10; g++ {
+		go func() {
+			defer wg.Done()
+			s.Increment(h.Hash(1))
+		}()
+	}
+	wg.Wait()
+	if n := s.Estimate(h.Hash(1)); n != 10 {
+		t.Fatalf("concurrent increments should not be lost, got %d", n)
+	}
+}
+
+func TestTinyLFU(t *testing.T) {
+	f := NewTinyLFU(100, 50)
+	h := NewHasher[int]()
+
+	f.Increment(h.Hash(1))
+	if n := f.Estimate(h.Hash(1)); n != 1 {
+		t.Fatalf("first access should be kept by doorkeeper, got %d", n)
+	}
+	if n := f.sketch.Estimate(h.Hash(1)); n != 0 {
+		t.Fatalf("first access should not reach sketch, got %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		f.Increment(h.Hash(1))
+	}
+	if n := f.Estimate(h.Hash(1)); n != 4 {
+		t.Fatalf("should estimate 4, got %d", n)
+	}
+
+	// 到达sampleSize后重置
+	for i := 0; f.additions.Load() != 0; i++ {
+		f.Increment(h.Hash(1000 + i))
+	}
+	if n := f.Estimate(h.Hash(1)); n != 1 {
+		t.Fatalf("should halve after sample, got %d", n)
+	}
+}
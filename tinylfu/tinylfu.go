@@ -0,0 +1,82 @@
+package tinylfu
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+)
+
+// TinyLFU 准入判断用的频率估计: Doorkeeper + CountMinSketch
+// 每Increment sampleSize次，清空doorkeeper并把sketch计数减半，让频率反映最近一段时间的访问
+// 可并发使用
+type TinyLFU struct {
+	door   *Doorkeeper
+	sketch *CountMinSketch
+
+	sampleSize uint64
+	additions  atomic.Uint64
+}
+
+const (
+	// defaultSampleRatio 未指定sampleSize时取容量的10倍
+	defaultSampleRatio       = 10
+	defaultFalsePositiveRate = 0.01
+)
+
+// NewTinyLFU capacity为缓存容量，sampleSize为重置周期，小于等于0时取容量的10倍
+func NewTinyLFU(capacity, sampleSize int) *TinyLFU {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if sampleSize <= 0 {
+		sampleSize = capacity * defaultSampleRatio
+	}
+	return &TinyLFU{
+		door:       NewDoorkeeper(sampleSize, defaultFalsePositiveRate),
+		sketch:     NewCountMinSketch(capacity),
+		sampleSize: uint64(sampleSize),
+	}
+}
+
+// Increment 记录一次h的访问。第一次只放入doorkeeper，之后才在sketch中计数
+func (t *TinyLFU) Increment(h uint64) {
+	if t.door.Put(h) {
+		t.sketch.Increment(h)
+	}
+	if t.additions.Add(1) == t.sampleSize {
+		t.Reset()
+	}
+}
+
+// Estimate 返回h的估计访问次数，doorkeeper中存在也计1次
+func (t *TinyLFU) Estimate(h uint64) uint8 {
+	n := t.sketch.Estimate(h)
+	if t.door.Contains(h) {
+		n++
+	}
+	return n
+}
+
+// Reset 清空doorkeeper，sketch计数减半
+func (t *TinyLFU) Reset() {
+	t.additions.Store(0)
+	t.door.Reset()
+	t.sketch.Reset()
+}
+
+// SampleSize 重置周期
+func (t *TinyLFU) SampleSize() int {
+	return int(t.sampleSize)
+}
+
+// Hasher 为sketch、doorkeeper计算key的hash，同一Hasher对同一key的结果不变
+type Hasher[K comparable] struct {
+	seed maphash.Seed
+}
+
+func NewHasher[K comparable]() Hasher[K] {
+	return Hasher[K]{seed: maphash.MakeSeed()}
+}
+
+func (h Hasher[K]) Hash(key K) uint64 {
+	return maphash.Comparable(h.seed, key)
+}
@@ -0,0 +1,146 @@
+package workload
+
+import "math/rand/v2"
+
+// Uniform 在[0, n)上均匀分布的访问
+type Uniform struct {
+	r *rand.Rand
+	n uint64
+}
+
+func NewUniform(seed uint64, n uint64) *Uniform {
+	if n < 1 {
+		n = 1
+	}
+	return &Uniform{r: newRand(seed), n: n}
+}
+
+func (u *Uniform) Next() Access {
+	return Access{Key: u.r.Uint64N(u.n)}
+}
+
+// Scan 从start开始依次访问，永不重复，模拟一次性的全表扫描
+type Scan struct {
+	next uint64
+}
+
+func NewScan(start uint64) *Scan {
+	return &Scan{next: start}
+}
+
+func (s *Scan) Next() Access {
+	k := s.next
+	s.next++
+	return Access{Key: k}
+}
+
+// Loop 依次循环访问[start, start+n)，循环长度略大于缓存容量时lru的命中率为0
+type Loop struct {
+	start uint64
+	n     uint64
+	i     uint64
+}
+
+func NewLoop(start, n uint64) *Loop {
+	if n < 1 {
+		n = 1
+	}
+	return &Loop{start: start, n: n}
+}
+
+func (l *Loop) Next() Access {
+	k := l.start + l.i
+	l.i = (l.i + 1) % l.n
+	return Access{Key: k}
+}
+
+// ShiftingHotspot 以hotProb的概率访问热点区间中的key，否则在[0, n)上均匀访问
+// 每shiftEvery次访问热点区间整体平移hotSize，模拟热点随时间转移
+type ShiftingHotspot struct {
+	r          *rand.Rand
+	n          uint64
+	hotSize    uint64
+	hotProb    float64
+	shiftEvery int
+	offset     uint64
+	count      int
+}
+
+func NewShiftingHotspot(seed uint64, n, hotSize uint64, hotProb float64, shiftEvery int) *ShiftingHotspot {
+	if n < 1 {
+		n = 1
+	}
+	if hotSize < 1 || hotSize > n {
+		hotSize = n
+	}
+	return &ShiftingHotspot{
+		r:          newRand(seed),
+		n:          n,
+		hotSize:    hotSize,
+		hotProb:    hotProb,
+		shiftEvery: shiftEvery,
+	}
+}
+
+func (s *ShiftingHotspot) Next() Access {
+	if s.shiftEvery > 0 && s.count > 0 && s.count%s.shiftEvery == 0 {
+		s.offset = (s.offset + s.hotSize) % s.n
+	}
+	s.count++
+	if s.r.Float64() < s.hotProb {
+		return Access{Key: (s.offset + s.r.Uint64N(s.hotSize)) % s.n}
+	}
+	return Access{Key: s.r.Uint64N(s.n)}
+}
+
+// Mixed 在g的基础上以writeRatio的概率把访问标记为写
+type Mixed struct {
+	r          *rand.Rand
+	g          Generator
+	writeRatio float64
+}
+
+func NewMixed(seed uint64, g Generator, writeRatio float64) *Mixed {
+	return &Mixed{r: newRand(seed), g: g, writeRatio: writeRatio}
+}
+
+func (m *Mixed) Next() Access {
+	a := m.g.Next()
+	if m.r.Float64() < m.writeRatio {
+		a.Op = Write
+	} else {
+		a.Op = Read
+	}
+	return a
+}
+
+// Interleave 按权重随机从多个生成器中取下一次访问，比如zipf中夹杂扫描
+type Interleave struct {
+	r       *rand.Rand
+	gens    []Generator
+	weights []float64
+	total   float64
+}
+
+// NewInterleave weights与gens一一对应
+func NewInterleave(seed uint64, weights []float64, gens ...Generator) *Interleave {
+	if len(weights) != len(gens) {
+		panic("workload: weights and generators mismatch")
+	}
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	return &Interleave{r: newRand(seed), gens: gens, weights: weights, total: total}
+}
+
+func (in *Interleave) Next() Access {
+	x := in.r.Float64() * in.total
+	for i, w := range in.weights {
+		if x < w {
+			return in.gens[i].Next()
+		}
+		x -= w
+	}
+	return in.gens[len(in.gens)-1].Next()
+}
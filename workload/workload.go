@@ -0,0 +1,54 @@
+// Package workload 生成用于评估缓存的合成访问序列
+// 所有生成器由seed决定，同一seed生成的序列完全相同，便于在测试、benchmark、ihe-sim之间复现
+// 生成器都不是线程安全的，并发使用时先用Keys、Take生成好再分给各goroutine
+package workload
+
+import (
+	"math/rand/v2"
+	"strconv"
+)
+
+type Op uint8
+
+const (
+	Read Op = iota
+	Write
+)
+
+// Access 一次访问
+type Access struct {
+	Key uint64
+	Op  Op
+}
+
+// KeyString 以十进制字符串表示的key，供string key的缓存使用
+func (a Access) KeyString() string {
+	return strconv.FormatUint(a.Key, 10)
+}
+
+type Generator interface {
+	Next() Access
+}
+
+// Take 生成n次访问
+func Take(g Generator, n int) []Access {
+	accesses := make([]Access, n)
+	for i := range accesses {
+		accesses[i] = g.Next()
+	}
+	return accesses
+}
+
+// Keys 生成n次访问的key字符串
+func Keys(g Generator, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = g.Next().KeyString()
+	}
+	return keys
+}
+
+// newRand 由seed得到确定的随机数序列
+func newRand(seed uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+}
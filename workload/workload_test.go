@@ -0,0 +1,103 @@
+package workload
+
+import "testing"
+
+func TestDeterministic(t *testing.T) {
+	gens := []func() Generator{
+		func() Generator { return NewZipf(1, 1000, 0.99) },
+		func() Generator { return NewZipf(1, 1000, 1.2) },
+		func() Generator { return NewUniform(1, 1000) },
+		func() Generator { return NewShiftingHotspot(1, 1000, 10, 0.9, 100) },
+		func() Generator { return NewMixed(1, NewZipf(2, 1000, 0.99), 0.1) },
+	}
+	for i, g := range gens {
+		a, b := Take(g(), 1000), Take(g(), 1000)
+		for j := range a {
+			if a[j] != b[j] {
+				t.Fatalf("generator %d differs at %d: %v %v", i, j, a[j], b[j])
+			}
+		}
+	}
+	if Take(NewZipf(1, 1000, 0.99), 10)[0] == Take(NewZipf(2, 1000, 0.99), 10)[0] &&
+		Take(NewZipf(1, 1000, 0.99), 10)[1] == Take(NewZipf(2, 1000, 0.99), 10)[1] {
+		t.Fatal("different seeds should differ")
+	}
+}
+
+func TestZipfSkew(t *testing.T) {
+	n := uint64(1000)
+	for _, theta := range []float64{0.99, 1.2} {
+		counts := make([]int, n)
+		for _, a := range Take(NewZipf(1, n, theta), 100000) {
+			if a.Key >= n {
+				t.Fatalf("key %d out of range", a.Key)
+			}
+			counts[a.Key]++
+		}
+		hot := 0
+		for _, c := range counts[:n/10] {
+			hot += c
+		}
+		if counts[0] < counts[1] || counts[1] < counts[n-1] || hot < 50000 {
+			t.Fatalf("theta %v should be skewed, top 10%% got %d", theta, hot)
+		}
+	}
+
+	counts := make([]int, 10)
+	for _, a := range Take(NewZipf(1, 10, 0), 10000) {
+		counts[a.Key]++
+	}
+	for k, c := range counts {
+		if c < 800 || c > 1200 {
+			t.Fatalf("theta 0 should be uniform, key %d got %d", k, c)
+		}
+	}
+}
+
+func TestScanLoop(t *testing.T) {
+	for i, a := range Take(NewScan(100), 5) {
+		if a.Key != uint64(100+i) {
+			t.Fatalf("scan should be sequential, got %v", a)
+		}
+	}
+	keys := Keys(NewLoop(10, 3), 7)
+	want := []string{"10", "11", "12", "10", "11", "12", "10"}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("loop want %v, got %v", want, keys)
+		}
+	}
+}
+
+func TestShiftingHotspot(t *testing.T) {
+	g := NewShiftingHotspot(1, 1000, 10, 1, 100)
+	for round := uint64(0); round < 3; round++ {
+		for _, a := range Take(g, 100) {
+			if a.Key < round*10 || a.Key >= round*10+10 {
+				t.Fatalf("round %d key %d should be in hotspot", round, a.Key)
+			}
+		}
+	}
+}
+
+func TestMixedInterleave(t *testing.T) {
+	writes := 0
+	for _, a := range Take(NewMixed(1, NewUniform(1, 100), 0.2), 10000) {
+		if a.Op == Write {
+			writes++
+		}
+	}
+	if writes < 1800 || writes > 2200 {
+		t.Fatalf("should write about 20%%, got %d", writes)
+	}
+
+	scans := 0
+	for _, a := range Take(NewInterleave(1, []float64{9, 1}, NewZipf(1, 100, 0.99), NewScan(1000)), 10000) {
+		if a.Key >= 1000 {
+			scans++
+		}
+	}
+	if scans < 800 || scans > 1200 {
+		t.Fatalf("should scan about 10%%, got %d", scans)
+	}
+}
@@ -0,0 +1,77 @@
+package workload
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Zipf 在[0, n)上服从Zipf分布的访问，key越小越热。theta越大越倾斜，YCSB默认0.99
+// theta在(0, 1)时使用YCSB的生成算法，大于1时使用rand.Zipf，等于1时按0.9999处理，小于等于0时退化为均匀分布
+type Zipf struct {
+	r *rand.Rand
+	n uint64
+
+	// YCSB
+	theta float64
+	zetan float64
+	alpha float64
+	eta   float64
+	half  float64
+
+	zipf *rand.Zipf
+}
+
+func NewZipf(seed uint64, n uint64, theta float64) *Zipf {
+	if n < 1 {
+		n = 1
+	}
+	z := &Zipf{r: newRand(seed), n: n, theta: theta}
+	switch {
+	case theta > 1:
+		z.zipf = rand.NewZipf(z.r, theta, 1, n-1)
+	case theta > 0:
+		if theta == 1 {
+			z.theta = 0.9999
+		}
+		z.zetan = zeta(n, z.theta)
+		z.alpha = 1 / (1 - z.theta)
+		z.eta = (1 - math.Pow(2/float64(n), 1-z.theta)) / (1 - zeta(2, z.theta)/z.zetan)
+		z.half = 1 + math.Pow(0.5, z.theta)
+	}
+	return z
+}
+
+func (z *Zipf) Next() Access {
+	return Access{Key: z.next()}
+}
+
+func (z *Zipf) next() uint64 {
+	if z.zipf != nil {
+		return z.zipf.Uint64()
+	}
+	if z.theta <= 0 {
+		return z.r.Uint64N(z.n)
+	}
+	u := z.r.Float64()
+	uz := u * z.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < z.half && z.n > 1 {
+		return 1
+	}
+	k := uint64(float64(z.n) * math.Pow(z.eta*u-z.eta+1, z.alpha))
+	if k >= z.n {
+		k = z.n - 1
+	}
+	return k
+}
+
+// zeta 前n项的广义调和数，n很大时构造Zipf需要O(n)
+func zeta(n uint64, theta float64) float64 {
+	var sum float64
+	for i := uint64(1); i <= n; i++ {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}